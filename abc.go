@@ -0,0 +1,223 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+//ABC's names for the modes in the K: field, major being the default
+var abcModes = []string{
+	major:      "",
+	minor:      "m",
+	dorian:     "dor",
+	phrygian:   "phr",
+	lydian:     "lyd",
+	mixolydian: "mix",
+	locrian:    "loc",
+}
+
+//abcUnit is the default note length (L:1/8) in ticks
+const abcUnit = ticksPerQuarter / 2
+
+//how many measures go on a line in the tune file
+const abcMeasuresPerLine = 4
+
+//writeABC writes the recording as an ABC tune with a voice per staff
+func writeABC(w io.Writer, r *recorder) error {
+	s := r.session()
+	treble, bass := s.staves()
+
+	out := bufio.NewWriter(w)
+	writeABCHeader(out)
+
+	for i, notes := range [][]playedNote{treble, bass} {
+		fmt.Fprintf(out, "V:%d\n", i+1)
+
+		measures := s.measures(notes, s.measureCount())
+		for m, measure := range measures {
+			fmt.Fprint(out, abcMeasure(measure))
+
+			switch {
+			case m == len(measures)-1:
+				fmt.Fprintln(out, " |]")
+			case (m+1)%abcMeasuresPerLine == 0:
+				fmt.Fprintln(out, " |")
+			default:
+				fmt.Fprint(out, " | ")
+			}
+		}
+	}
+
+	return out.Flush()
+}
+
+func writeABCHeader(out io.Writer) {
+	fmt.Fprintln(out, "X:1")
+	fmt.Fprintln(out, "T:live-score")
+	fmt.Fprintf(out, "M:%s\n", timeSig)
+	fmt.Fprintln(out, "L:1/8")
+	fmt.Fprintf(out, "Q:1/4=%.0f\n", beatsPerMinute)
+	fmt.Fprintln(out, "V:1 clef=treble")
+	fmt.Fprintln(out, "V:2 clef=bass")
+	fmt.Fprintf(out, "K:%s\n", abcKey(currentKey()))
+}
+
+//abcKey is a key as written in the K: field, e.g. "Bb" or "F#m"
+func abcKey(k musicKey) string {
+	tonic := string(k.tonic)
+	if k.alter > 0 {
+		tonic += strings.Repeat("#", k.alter)
+	}
+	if k.alter < 0 {
+		tonic += strings.Repeat("b", -k.alter)
+	}
+
+	return tonic + abcModes[k.mode]
+}
+
+//abcPitch writes a note without its accidental. C is middle C, c the
+//octave above it.
+func abcPitch(n spelledNote) string {
+	if n.octave >= 5 {
+		return strings.ToLower(string(n.letter)) + strings.Repeat("'", n.octave-5)
+	}
+
+	return string(n.letter) + strings.Repeat(",", 4-n.octave)
+}
+
+func abcAccidental(alter int) string {
+	switch {
+	case alter > 0:
+		return strings.Repeat("^", alter)
+	case alter < 0:
+		return strings.Repeat("_", -alter)
+	}
+
+	return "="
+}
+
+//abcDuration writes a note value as a multiple of the default length.
+//Triplets are written at the value they stand in for, the (3 in front
+//takes care of the rest.
+func abcDuration(v noteValue) string {
+	ticks := v.ticks
+	if v.triplet {
+		ticks = ticks * 3 / 2
+	}
+
+	divisor := gcd(ticks, abcUnit)
+	num, den := ticks/divisor, abcUnit/divisor
+
+	switch {
+	case num == 1 && den == 1:
+		return ""
+	case den == 1:
+		return fmt.Sprint(num)
+	case num == 1:
+		return fmt.Sprint("/", den)
+	}
+
+	return fmt.Sprintf("%d/%d", num, den)
+}
+
+//abcMeasure writes one measure of a staff. Like on paper an accidental lasts
+//until the barline, so it is only written when a note differs from what the
+//key signature or an earlier note in the measure says.
+func abcMeasure(measure []notatedChord) string {
+	type line struct {
+		letter byte
+		octave int
+	}
+	alters := map[line]int{}
+
+	parts := []string{}
+	for i, c := range measure {
+		if c.value.triplet && (i == 0 || !measure[i-1].value.triplet) {
+			run := 0
+			for i+run < len(measure) && measure[i+run].value.triplet {
+				run++
+			}
+			parts = append(parts, fmt.Sprintf("(3:2:%d", run))
+		}
+
+		if c.isRest() {
+			parts = append(parts, "z"+abcDuration(c.value))
+			continue
+		}
+
+		pitches := ""
+		for i, p := range c.notes {
+			spelled := spell(p.note)
+			l := line{spelled.letter, spelled.octave}
+
+			alter, ok := alters[l]
+			if !ok {
+				alter = keyAlter(spelled.letter)
+			}
+			if alter != spelled.alter {
+				pitches += abcAccidental(spelled.alter)
+				alters[l] = spelled.alter
+			}
+
+			pitches += abcPitch(spelled)
+			//in a chord each note is tied on its own
+			if c.tiedToNext[i] && len(c.notes) > 1 {
+				pitches += "-"
+			}
+		}
+
+		part := pitches
+		if len(c.notes) > 1 {
+			part = "[" + pitches + "]"
+		}
+		part += abcDuration(c.value)
+		if len(c.notes) == 1 && c.tiedToNext[0] {
+			part += "-"
+		}
+
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+//printABC prints the transcription while it is being played, every measure
+//once it is over. The header waits for the first note, which is where the
+//first measure starts.
+func printABC() {
+	started := false
+	printed := 0
+
+	for range time.Tick(quarterLength()) {
+		s := recording.sessionUntil(time.Now())
+		if len(s.notes) == 0 {
+			continue
+		}
+		if !started {
+			writeABCHeader(os.Stdout)
+			started = true
+		}
+
+		//a note played right on the barline may come in a little late
+		over := int((time.Since(s.start) - quarterLength()/2) / measureLength())
+		if over <= printed {
+			continue
+		}
+
+		treble, bass := s.staves()
+		trebleMeasures := s.measures(treble, over)
+		bassMeasures := s.measures(bass, over)
+		for ; printed < over; printed++ {
+			fmt.Fprintf(os.Stdout, "[V:1] %s |\n", abcMeasure(trebleMeasures[printed]))
+			fmt.Fprintf(os.Stdout, "[V:2] %s |\n", abcMeasure(bassMeasures[printed]))
+		}
+	}
+}
@@ -0,0 +1,359 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build alsa
+// +build alsa
+
+package main
+
+/*
+#cgo LDFLAGS: -lasound
+#include <alsa/asoundlib.h>
+
+//these are macros in alsa-lib, which cgo can't call
+
+static void prepare_output(snd_seq_event_t *ev, int port) {
+	snd_seq_ev_set_source(ev, port);
+	snd_seq_ev_set_subs(ev);
+	snd_seq_ev_set_direct(ev);
+}
+
+static int event_type(snd_seq_event_t *ev) {
+	return ev->type;
+}
+
+static snd_seq_addr_t event_addr(snd_seq_event_t *ev) {
+	return ev->data.addr;
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const alsaClientName = "live-score"
+
+//alsaAvailable returns why -backend alsa can't be used, if it can't
+func alsaAvailable() error {
+	return nil
+}
+
+//alsaInput reads from the ALSA sequencer. It either subscribes to a given
+//port, or just offers a port of its own that others can connect to.
+type alsaInput struct {
+	//port to subscribe to as given by the user, e.g. "20:0" or
+	//"Digital Piano". Empty if we wait for others to connect to us.
+	address string
+	remote  C.snd_seq_addr_t
+
+	seq  *C.snd_seq_t
+	port C.int
+
+	//converting between sequencer events and raw midi bytes
+	toBytes   *C.snd_midi_event_t
+	fromBytes *C.snd_midi_event_t
+	buf       []byte
+	pending   []midiEvent
+}
+
+func alsaError(ret C.int) error {
+	if ret >= 0 {
+		return nil
+	}
+
+	return fmt.Errorf("alsa: %s", C.GoString(C.snd_strerror(ret)))
+}
+
+func openAlsa() (*C.snd_seq_t, error) {
+	var seq *C.snd_seq_t
+
+	name := C.CString("default")
+	defer C.free(unsafe.Pointer(name))
+
+	err := alsaError(C.snd_seq_open(&seq, name, C.SND_SEQ_OPEN_DUPLEX, 0))
+	return seq, err
+}
+
+func newAlsaInput(address string) (*alsaInput, error) {
+	seq, err := openAlsa()
+	if err != nil {
+		return nil, err
+	}
+
+	a := &alsaInput{
+		address: address,
+		seq:     seq,
+		buf:     make([]byte, 1024),
+	}
+
+	name := C.CString(alsaClientName)
+	defer C.free(unsafe.Pointer(name))
+	C.snd_seq_set_client_name(seq, name)
+
+	a.port = C.snd_seq_create_simple_port(
+		seq,
+		name,
+		C.SND_SEQ_PORT_CAP_WRITE|C.SND_SEQ_PORT_CAP_SUBS_WRITE|
+			C.SND_SEQ_PORT_CAP_READ|C.SND_SEQ_PORT_CAP_SUBS_READ,
+		C.SND_SEQ_PORT_TYPE_MIDI_GENERIC|C.SND_SEQ_PORT_TYPE_APPLICATION,
+	)
+	if err := alsaError(a.port); err != nil {
+		C.snd_seq_close(seq)
+		return nil, err
+	}
+
+	C.snd_midi_event_new(C.size_t(len(a.buf)), &a.toBytes)
+	C.snd_midi_event_new(C.size_t(len(a.buf)), &a.fromBytes)
+	//every event should be complete on its own, we run our own decoder
+	//over the bytes
+	C.snd_midi_event_no_status(a.toBytes, 1)
+
+	if address == "" {
+		return a, nil
+	}
+
+	//hear about ports going away, so we can treat it like an unplugged
+	//device file
+	C.snd_seq_connect_from(
+		seq,
+		a.port,
+		C.SND_SEQ_CLIENT_SYSTEM,
+		C.SND_SEQ_PORT_SYSTEM_ANNOUNCE,
+	)
+
+	if err := a.subscribe(); err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *alsaInput) subscribe() error {
+	address := C.CString(a.address)
+	defer C.free(unsafe.Pointer(address))
+
+	if err := alsaError(C.snd_seq_parse_address(a.seq, &a.remote, address)); err != nil {
+		return err
+	}
+
+	err := alsaError(C.snd_seq_connect_from(
+		a.seq,
+		a.port,
+		C.int(a.remote.client),
+		C.int(a.remote.port),
+	))
+	if err != nil {
+		return err
+	}
+
+	//for echoing back, not every port can be written to so it's fine if
+	//this doesn't work
+	C.snd_seq_connect_to(a.seq, a.port, C.int(a.remote.client), C.int(a.remote.port))
+
+	return nil
+}
+
+//openAlsaInputs subscribes to every address given, or creates a single port
+//for others to connect to if there are none
+func openAlsaInputs(addresses []string) []midiInput {
+	ret := []midiInput{}
+
+	if len(addresses) == 0 {
+		a, err := newAlsaInput("")
+		assertOK(err)
+
+		fmt.Println("# Created ALSA sequencer port", a.name()+", connect a device to it (e.g. with aconnect) #")
+		return append(ret, a)
+	}
+
+	for _, address := range addresses {
+		a, err := newAlsaInput(address)
+		if err != nil {
+			fmt.Println("Unable to subscribe to ALSA sequencer port", address+":", err)
+			fmt.Println("Waiting for it to show up, or ^C to cancel.")
+			a = waitForAlsaInput(address)
+		}
+
+		fmt.Println("# Subscribed to ALSA sequencer port", a.description(), "#")
+		ret = append(ret, a)
+	}
+
+	return ret
+}
+
+func waitForAlsaInput(address string) *alsaInput {
+	for {
+		time.Sleep(reconnectInterval)
+
+		if a, err := newAlsaInput(address); err == nil {
+			return a
+		}
+	}
+}
+
+func (a *alsaInput) next() (midiEvent, error) {
+	for len(a.pending) == 0 {
+		var ev *C.snd_seq_event_t
+
+		ret := C.snd_seq_event_input(a.seq, &ev)
+		if ret == -C.int(syscall.ENOSPC) {
+			//we were too slow and some events were dropped, nothing to
+			//do about that now
+			continue
+		}
+		if err := alsaError(ret); err != nil {
+			return midiEvent{}, err
+		}
+
+		switch C.event_type(ev) {
+		case C.SND_SEQ_EVENT_CLIENT_EXIT:
+			if C.event_addr(ev).client == a.remote.client {
+				return midiEvent{}, errDisconnected
+			}
+			continue
+
+		case C.SND_SEQ_EVENT_PORT_EXIT:
+			addr := C.event_addr(ev)
+			if addr.client == a.remote.client && addr.port == a.remote.port {
+				return midiEvent{}, errDisconnected
+			}
+			continue
+		}
+
+		n := C.snd_midi_event_decode(
+			a.toBytes,
+			(*C.uchar)(&a.buf[0]),
+			C.long(len(a.buf)),
+			ev,
+		)
+		if n <= 0 {
+			//not something that exists as a midi message
+			continue
+		}
+
+		midi := newMidiDecoder(bytes.NewReader(a.buf[:n]))
+		for {
+			e, err := midi.next()
+			if err != nil {
+				break
+			}
+			a.pending = append(a.pending, e)
+		}
+	}
+
+	ev := a.pending[0]
+	a.pending = a.pending[1:]
+	return ev, nil
+}
+
+//Write sends raw midi bytes to everyone subscribed to our port, which
+//includes the port we read from if it accepts input
+func (a *alsaInput) Write(b []byte) (int, error) {
+	C.snd_midi_event_reset_encode(a.fromBytes)
+
+	written := 0
+	for written < len(b) {
+		var ev C.snd_seq_event_t
+		ev._type = C.SND_SEQ_EVENT_NONE
+
+		n := C.snd_midi_event_encode(
+			a.fromBytes,
+			(*C.uchar)(&b[written]),
+			C.long(len(b)-written),
+			&ev,
+		)
+		if n <= 0 {
+			return written, alsaError(C.int(n))
+		}
+		written += int(n)
+
+		if C.event_type(&ev) == C.SND_SEQ_EVENT_NONE {
+			//message isn't complete yet
+			continue
+		}
+
+		C.prepare_output(&ev, a.port)
+		if err := alsaError(C.snd_seq_event_output_direct(a.seq, &ev)); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+func (a *alsaInput) Close() error {
+	C.snd_midi_event_free(a.toBytes)
+	C.snd_midi_event_free(a.fromBytes)
+	return alsaError(C.snd_seq_close(a.seq))
+}
+
+func (a *alsaInput) name() string {
+	if a.address == "" {
+		return fmt.Sprintf("%d:%d", C.snd_seq_client_id(a.seq), a.port)
+	}
+
+	return fmt.Sprintf("%d:%d", a.remote.client, a.remote.port)
+}
+
+func (a *alsaInput) description() string {
+	if a.address == "" {
+		return alsaClientName + " " + a.name()
+	}
+
+	return a.address + " (" + a.name() + ")"
+}
+
+func (a *alsaInput) reopen() midiInput {
+	return waitForAlsaInput(a.address)
+}
+
+//printAlsaPorts lists every sequencer port that can be subscribed to
+func printAlsaPorts() {
+	seq, err := openAlsa()
+	assertOK(err)
+	defer C.snd_seq_close(seq)
+
+	var client *C.snd_seq_client_info_t
+	var port *C.snd_seq_port_info_t
+	C.snd_seq_client_info_malloc(&client)
+	defer C.snd_seq_client_info_free(client)
+	C.snd_seq_port_info_malloc(&port)
+	defer C.snd_seq_port_info_free(port)
+
+	const readable = C.SND_SEQ_PORT_CAP_READ | C.SND_SEQ_PORT_CAP_SUBS_READ
+
+	found := false
+	C.snd_seq_client_info_set_client(client, -1)
+	for C.snd_seq_query_next_client(seq, client) >= 0 {
+		clientID := C.snd_seq_client_info_get_client(client)
+		if clientID == C.SND_SEQ_CLIENT_SYSTEM {
+			continue
+		}
+
+		C.snd_seq_port_info_set_client(port, clientID)
+		C.snd_seq_port_info_set_port(port, -1)
+		for C.snd_seq_query_next_port(seq, port) >= 0 {
+			if C.snd_seq_port_info_get_capability(port)&readable != readable {
+				continue
+			}
+
+			found = true
+			fmt.Printf("%-20s %s: %s\n",
+				fmt.Sprintf("%d:%d", clientID, C.snd_seq_port_info_get_port(port)),
+				C.GoString(C.snd_seq_client_info_get_name(client)),
+				C.GoString(C.snd_seq_port_info_get_name(port)),
+			)
+		}
+	}
+
+	if !found {
+		fmt.Println("No ALSA sequencer ports found.")
+	}
+}
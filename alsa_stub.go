@@ -0,0 +1,28 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !alsa
+// +build !alsa
+
+package main
+
+import (
+	"errors"
+)
+
+//without the alsa build tag the ALSA headers aren't needed to build, and
+//only device files can be read
+
+//alsaAvailable returns why -backend alsa can't be used, if it can't
+func alsaAvailable() error {
+	return errors.New("this build has no ALSA sequencer support, build with -tags alsa to use it")
+}
+
+//openAlsaInputs is never called, alsaAvailable is checked first
+func openAlsaInputs(addresses []string) []midiInput {
+	return nil
+}
+
+//printAlsaPorts is never called, alsaAvailable is checked first
+func printAlsaPorts() {}
@@ -0,0 +1,425 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+//chordTone is an interval above the root: how many semitones, and how many
+//letters, so that e.g. a ♯9 and a minor third can be told apart
+type chordTone struct {
+	semitones int
+	steps     int
+}
+
+type chordType struct {
+	//what comes after the root in the chord symbol
+	suffix string
+	tones  []chordTone
+}
+
+//tones that are used all over the table below
+var (
+	unison         = chordTone{0, 0}
+	flatNinth      = chordTone{1, 1}
+	ninth          = chordTone{2, 1}
+	sharpNinth     = chordTone{3, 1}
+	minThird       = chordTone{3, 2}
+	majThird       = chordTone{4, 2}
+	majSecond      = chordTone{2, 1}
+	perfFourth     = chordTone{5, 3}
+	eleventh       = chordTone{5, 3}
+	sharpEleventh  = chordTone{6, 3}
+	flatFifth      = chordTone{6, 4}
+	perfFifth      = chordTone{7, 4}
+	sharpFifth     = chordTone{8, 4}
+	flatThirteenth = chordTone{8, 5}
+	majSixth       = chordTone{9, 5}
+	thirteenth     = chordTone{9, 5}
+	dimSeventh     = chordTone{9, 6}
+	minSeventh     = chordTone{10, 6}
+	majSeventh     = chordTone{11, 6}
+)
+
+//chordTypes in order of preference: when a set of notes fits several chords,
+//the one further up wins
+var chordTypes = []chordType{
+	{"", []chordTone{unison, majThird, perfFifth}},
+	{"m", []chordTone{unison, minThird, perfFifth}},
+	{"7", []chordTone{unison, majThird, perfFifth, minSeventh}},
+	{"maj7", []chordTone{unison, majThird, perfFifth, majSeventh}},
+	{"m7", []chordTone{unison, minThird, perfFifth, minSeventh}},
+	{"dim", []chordTone{unison, minThird, flatFifth}},
+	{"aug", []chordTone{unison, majThird, sharpFifth}},
+	{"sus4", []chordTone{unison, perfFourth, perfFifth}},
+	{"sus2", []chordTone{unison, majSecond, perfFifth}},
+	{"6", []chordTone{unison, majThird, perfFifth, majSixth}},
+	{"m6", []chordTone{unison, minThird, perfFifth, majSixth}},
+	{"dim7", []chordTone{unison, minThird, flatFifth, dimSeventh}},
+	{"m7♭5", []chordTone{unison, minThird, flatFifth, minSeventh}},
+	{"m(maj7)", []chordTone{unison, minThird, perfFifth, majSeventh}},
+	{"7sus4", []chordTone{unison, perfFourth, perfFifth, minSeventh}},
+	{"7♯5", []chordTone{unison, majThird, sharpFifth, minSeventh}},
+	{"maj7♯5", []chordTone{unison, majThird, sharpFifth, majSeventh}},
+	{"7♭5", []chordTone{unison, majThird, flatFifth, minSeventh}},
+	{"add9", []chordTone{unison, ninth, majThird, perfFifth}},
+	{"m(add9)", []chordTone{unison, ninth, minThird, perfFifth}},
+	{"9", []chordTone{unison, ninth, majThird, perfFifth, minSeventh}},
+	{"maj9", []chordTone{unison, ninth, majThird, perfFifth, majSeventh}},
+	{"m9", []chordTone{unison, ninth, minThird, perfFifth, minSeventh}},
+	{"6/9", []chordTone{unison, ninth, majThird, perfFifth, majSixth}},
+	{"7♭9", []chordTone{unison, flatNinth, majThird, perfFifth, minSeventh}},
+	{"7♯9", []chordTone{unison, sharpNinth, majThird, perfFifth, minSeventh}},
+	{"11", []chordTone{unison, ninth, eleventh, perfFifth, minSeventh}},
+	{"m11", []chordTone{unison, ninth, minThird, eleventh, perfFifth, minSeventh}},
+	{"7♯11", []chordTone{unison, majThird, sharpEleventh, perfFifth, minSeventh}},
+	{"maj7♯11", []chordTone{unison, majThird, sharpEleventh, perfFifth, majSeventh}},
+	{"7♭13", []chordTone{unison, majThird, perfFifth, flatThirteenth, minSeventh}},
+	{"13", []chordTone{unison, majThird, perfFifth, thirteenth, minSeventh}},
+	{"13", []chordTone{unison, ninth, majThird, perfFifth, thirteenth, minSeventh}},
+	{"maj13", []chordTone{unison, majThird, perfFifth, thirteenth, majSeventh}},
+	{"maj13", []chordTone{unison, ninth, majThird, perfFifth, thirteenth, majSeventh}},
+	{"m13", []chordTone{unison, ninth, minThird, perfFifth, thirteenth, minSeventh}},
+}
+
+//chord is a set of notes recognised as a chord
+type chord struct {
+	root  spelledNote
+	kind  chordType
+	tones []spelledNote
+
+	//bass is the lowest note, which is not necessarily the root
+	bass spelledNote
+	//0 for root position, 1 if the third is in the bass, etc. -1 if the
+	//bass isn't part of the chord at all (a slash chord like C/D)
+	inversion int
+	//the fifth is missing, which is fine for bigger chords
+	noFifth bool
+}
+
+//matches reports whether the intervals above a root are exactly this chord,
+//or this chord without its fifth if that still leaves at least three notes
+func (t chordType) matches(intervals map[int]bool) (ok bool, noFifth bool) {
+	if len(intervals) == len(t.tones) {
+		for _, tone := range t.tones {
+			if !intervals[tone.semitones] {
+				return false, false
+			}
+		}
+		return true, false
+	}
+
+	if len(intervals) == len(t.tones)-1 && len(t.tones) >= 4 {
+		for _, tone := range t.tones {
+			if tone != perfFifth && !intervals[tone.semitones] {
+				return false, false
+			}
+		}
+		return !intervals[perfFifth.semitones], true
+	}
+
+	return false, false
+}
+
+//recogniseChord finds the chord formed by some notes. ok is false for fewer
+//than three different pitch classes or anything that isn't in chordTypes.
+func recogniseChord(notes []byte) (c chord, ok bool) {
+	if len(notes) == 0 {
+		return c, false
+	}
+
+	bassNote := notes[0]
+	pitchClasses := map[int]bool{}
+	for _, n := range notes {
+		pitchClasses[int(n)%12] = true
+		if n < bassNote {
+			bassNote = n
+		}
+	}
+	bass := int(bassNote) % 12
+
+	if len(pitchClasses) < 3 {
+		return c, false
+	}
+
+	//the bass doesn't have to belong to the chord, as in C/D
+	withoutBass := map[int]bool{}
+	bassDoubled := false
+	for _, n := range notes {
+		if int(n)%12 != bass {
+			withoutBass[int(n)%12] = true
+		} else if n != bassNote {
+			bassDoubled = true
+		}
+	}
+
+	bestScore := -1
+	try := func(set map[int]bool, slash bool) {
+		//in order, so that ties always go the same way
+		for rootPitch := 0; rootPitch < 12; rootPitch++ {
+			if !set[rootPitch] {
+				continue
+			}
+
+			intervals := map[int]bool{}
+			for pc := range set {
+				intervals[(pc-rootPitch+12)%12] = true
+			}
+
+			for rank, t := range chordTypes {
+				matches, noFifth := t.matches(intervals)
+				if !matches {
+					continue
+				}
+
+				//lower is better
+				score := rank
+				//an incomplete chord only wins if no complete one fits,
+				//so C-E-A is Am/C rather than C6(no5)
+				if noFifth {
+					score += 60
+				}
+				if rootPitch != bass {
+					score += 50
+				}
+				if slash {
+					score += 10
+				}
+
+				if bestScore < 0 || score < bestScore {
+					bestScore = score
+					c = spellChord(rootPitch, t, bass)
+					c.noFifth = noFifth
+				}
+			}
+		}
+	}
+
+	try(pitchClasses, false)
+	if len(withoutBass) >= 3 && !bassDoubled {
+		try(withoutBass, true)
+	}
+
+	if bestScore >= 0 && c.kind.suffix == "dim7" {
+		tones := map[int]bool{}
+		for _, tone := range c.tones {
+			tones[tone.pitchClass()] = true
+		}
+		if root, ok := diminishedSeventhRoot(tones); ok {
+			c = spellChord(root, c.kind, bass)
+		}
+	}
+
+	return c, bestScore >= 0
+}
+
+//the degrees a diminished seventh chord most likely leads to, the tonic first
+var leadingToneTargets = []int{1, 5, 2, 6, 4, 3}
+
+//diminishedSeventhRoot picks the root of a diminished seventh chord. Its
+//notes are all a minor third apart, so each of them could be the root: it is
+//the leading tone of the key if that is in the chord, or else the leading
+//tone of a degree it can lead to, e.g. F♯ in C-E♭-F♯-A in C major.
+//ok is false if none of them leads anywhere in the current key.
+func diminishedSeventhRoot(pitchClasses map[int]bool) (int, bool) {
+	for _, degree := range leadingToneTargets {
+		if third, fifth := diatonicTriad(degree); third == 3 && fifth == 6 {
+			//diminished chords aren't tonicised
+			continue
+		}
+
+		leadingTone := (currentKey().degree(degree).pitchClass() + 11) % 12
+		if pitchClasses[leadingTone] {
+			return leadingTone, true
+		}
+	}
+
+	return 0, false
+}
+
+//spellChord picks the spelling of the root that fits the current key best,
+//counting how many of the chord's notes would need accidentals, and spells
+//the rest of the chord from it
+func spellChord(rootPitch int, t chordType, bass int) chord {
+	best := chord{}
+	bestCost := -1
+
+	for i := range letters {
+		alter := rootPitch - letterPitches[i]
+		if alter > 6 {
+			alter -= 12
+		}
+		if alter < -6 {
+			alter += 12
+		}
+		if abs(alter) > 1 {
+			continue
+		}
+
+		c := chord{
+			root: spelledNote{letter: letters[i], alter: alter},
+			kind: t,
+		}
+
+		cost := 0
+		for _, tone := range t.tones {
+			spelled := c.root.above(tone)
+			c.tones = append(c.tones, spelled)
+
+			cost += 10 * abs(spelled.alter-keyAlter(spelled.letter))
+			if abs(spelled.alter) > 1 {
+				cost += 100
+			}
+		}
+		//same as the note would be spelled on its own
+		if spell(byte(60+rootPitch)).letter != c.root.letter {
+			cost++
+		}
+
+		if bestCost < 0 || cost < bestCost {
+			best = c
+			bestCost = cost
+		}
+	}
+
+	best.inversion = -1
+	best.bass = spell(byte(60 + bass))
+	for i, tone := range best.tones {
+		if tone.pitchClass() == bass {
+			best.bass = tone
+			best.inversion = inversionOf(t.tones[i])
+		}
+	}
+
+	return best
+}
+
+//inversionOf tells which inversion a chord is in if this tone is the bass
+func inversionOf(tone chordTone) int {
+	switch tone.steps {
+	case 0:
+		return 0
+	case 2:
+		return 1
+	case 4:
+		return 2
+	case 6:
+		return 3
+	}
+
+	//9ths, suspensions and the like in the bass
+	return 4
+}
+
+//above spells the note a chord tone above n, ignoring octaves
+func (n spelledNote) above(tone chordTone) spelledNote {
+	letter := letters[(letterIndex(n.letter)+tone.steps)%7]
+	pitch := letterPitches[letterIndex(n.letter)] + n.alter + tone.semitones
+
+	alter := (pitch - letterPitches[letterIndex(letter)]) % 12
+	if alter > 6 {
+		alter -= 12
+	}
+	if alter < -6 {
+		alter += 12
+	}
+
+	return spelledNote{letter: letter, alter: alter}
+}
+
+func (n spelledNote) pitchClass() int {
+	return ((letterPitches[letterIndex(n.letter)]+n.alter)%12 + 12) % 12
+}
+
+//name is the letter and accidental, without the octave and without a sign
+//for naturals, e.g. "F♯"
+func (n spelledNote) name() string {
+	if n.alter == 0 {
+		return string(n.letter)
+	}
+
+	return string(n.letter) + accidentalString(n.alter)
+}
+
+func (c chord) String() string {
+	s := c.root.name() + c.kind.suffix
+	if c.noFifth {
+		s += "(no5)"
+	}
+	if c.inversion != 0 {
+		s += "/" + c.bass.name()
+	}
+
+	return s
+}
+
+var inversionNames = []string{
+	"root position",
+	"first inversion",
+	"second inversion",
+	"third inversion",
+}
+
+//description is the chord symbol with its inversion spelled out
+func (c chord) description() string {
+	switch {
+	case c.inversion < 0:
+		return c.String() + " (slash chord)"
+	case c.inversion < len(inversionNames):
+		return c.String() + " (" + inversionNames[c.inversion] + ")"
+	}
+
+	return c.String()
+}
+
+var asciiSymbols = strings.NewReplacer(
+	"𝄫", "bb",
+	"♭", "b",
+	"♮", "",
+	"♯", "#",
+	"𝄪", "x",
+	"ø", "/o",
+)
+
+//asciiName is the chord symbol without any special symbols, for raylib's
+//default font
+func (c chord) asciiName() string {
+	return asciiSymbols.Replace(c.String())
+}
+
+//chord recognises what is sounding right now, including notes held by the
+//pedals
+func (s stateSnapshot) chord() (chord, bool) {
+	return recogniseChord(s.noteNumbers())
+}
+
+//lastHarmony is what printHarmony printed last, so that each chord or
+//interval is only printed once
+var lastHarmony string
+
+//printHarmony prints the interval or chord that is sounding now if it
+//changed, along with the chord's function in the current key
+func printHarmony() {
+	sounding := state.snapshot()
+	name := ""
+
+	if i, ok := sounding.interval(); ok {
+		name = "Interval: " + i.String()
+	} else if c, ok := sounding.chord(); ok {
+		name = "Chord: " + c.description()
+		if numeral, ok := sounding.romanNumeral(); ok {
+			name += ", " + numeral + " in " + currentKey().String()
+		}
+	}
+
+	if name != lastHarmony {
+		lastHarmony = name
+		if name != "" {
+			fmt.Printf("# %s #\n", name)
+		}
+	}
+}
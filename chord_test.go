@@ -0,0 +1,110 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+)
+
+//inKeyOf switches to a key for a test, returning how to switch back
+func inKeyOf(t *testing.T, name string) func() {
+	t.Helper()
+
+	k, err := parseKey(name, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := currentKeySettings()
+	setKey(k)
+	return func() {
+		setKey(before.key)
+	}
+}
+
+func TestRecogniseChord(t *testing.T) {
+	tests := []struct {
+		key   string
+		notes []byte
+		want  string
+	}{
+		//root position and inversions
+		{"C", []byte{60, 64, 67}, "C"},
+		{"C", []byte{52, 60, 67}, "C/E"},
+		{"C", []byte{55, 64, 72}, "C/G"},
+		{"C", []byte{53, 59, 62, 67}, "G7/F"},
+		{"E♭", []byte{63, 67, 70}, "E♭"},
+		{"A", []byte{61, 64, 69}, "A/C♯"},
+
+		//the same pitch classes, told apart by the bass
+		{"C", []byte{57, 60, 64}, "Am"},
+		{"C", []byte{48, 57, 64}, "Am/C"},
+		{"C", []byte{48, 57, 64, 67}, "C6"},
+		{"C", []byte{57, 60, 64, 67}, "Am7"},
+
+		//a bass that isn't part of the chord
+		{"C", []byte{50, 60, 64, 67}, "C/D"},
+		{"C", []byte{38, 55, 59, 62}, "G/D"},
+
+		//the fifth can be left out of bigger chords only
+		{"C", []byte{55, 59, 65}, "G7(no5)"},
+
+		//diminished sevenths are rooted on a leading tone
+		{"C", []byte{59, 62, 65, 68}, "Bdim7"},
+		{"C", []byte{50, 53, 56, 59}, "Bdim7/D"},
+		{"C", []byte{53, 56, 59, 62}, "Bdim7/F"},
+		{"C", []byte{60, 63, 66, 69}, "F♯dim7/C"},
+		{"C", []byte{54, 60, 63, 69}, "F♯dim7"},
+		{"Am", []byte{56, 59, 62, 65}, "G♯dim7"},
+		{"Am", []byte{50, 53, 56, 59}, "G♯dim7/D"},
+	}
+
+	for _, test := range tests {
+		restore := inKeyOf(t, test.key)
+		c, ok := recogniseChord(test.notes)
+		restore()
+
+		if !ok {
+			t.Errorf("%v in %s: no chord, want %s", test.notes, test.key, test.want)
+			continue
+		}
+		if got := c.String(); got != test.want {
+			t.Errorf("%v in %s: got %s, want %s", test.notes, test.key, got, test.want)
+		}
+	}
+}
+
+func TestRecogniseChordNeedsThreePitchClasses(t *testing.T) {
+	for _, notes := range [][]byte{
+		nil,
+		{60},
+		{60, 72},
+		{60, 64, 72},
+	} {
+		if c, ok := recogniseChord(notes); ok {
+			t.Errorf("%v: got %s, want no chord", notes, c)
+		}
+	}
+}
+
+func TestRomanNumeralSlashChord(t *testing.T) {
+	defer inKeyOf(t, "C")()
+
+	tests := []struct {
+		notes []byte
+		want  string
+	}{
+		{[]byte{50, 60, 64, 67}, "I/2"},
+		{[]byte{54, 60, 64, 67}, "I/♯4"},
+		{[]byte{48, 60, 64, 67}, "I"},
+	}
+
+	for _, test := range tests {
+		got, ok := romanNumeral(test.notes)
+		if !ok || got != test.want {
+			t.Errorf("%v: got %q, want %q", test.notes, got, test.want)
+		}
+	}
+}
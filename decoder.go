@@ -0,0 +1,196 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+//midiEvent is one complete message taken from a midi stream
+type midiEvent struct {
+	//full status byte, for channel messages this includes the channel
+	status byte
+	//data bytes of the message, or the payload of a system exclusive
+	//message (without the F0/F7 framing)
+	data []byte
+
+	//where and when the event was received, filled in by whoever reads
+	//from the decoder
+	source *midiSource
+	time   time.Time
+}
+
+//kind returns the message type without the channel, so it can be compared
+//against NOTE_ON, CONTROL etc.
+func (e midiEvent) kind() byte {
+	if e.status < SYSTEM {
+		return e.status & 0xF0
+	}
+
+	return e.status
+}
+
+//eventTime is when an event was received, or now if nobody noted it down
+func eventTime(e midiEvent) time.Time {
+	if e.time.IsZero() {
+		return time.Now()
+	}
+
+	return e.time
+}
+
+func (e midiEvent) channel() byte {
+	return e.status & 0x0F
+}
+
+func (e midiEvent) isRealtime() bool {
+	return e.status >= TIMING_CLOCK
+}
+
+//bytes turns the event back into its wire format (without running status)
+func (e midiEvent) bytes() []byte {
+	if e.status == SYSTEM_EXCLUSIVE {
+		ret := append([]byte{SYSTEM_EXCLUSIVE}, e.data...)
+		return append(ret, SYSTEM_END_EXCLUSIVE)
+	}
+
+	return append([]byte{e.status}, e.data...)
+}
+
+//dataLength returns how many data bytes follow a given status byte, or -1
+//for system exclusive, which is terminated by SYSTEM_END_EXCLUSIVE instead
+func dataLength(status byte) int {
+	switch status & 0xF0 {
+	case NOTE_OFF, NOTE_ON, POLY_PRESSURE, CONTROL, PITCH_BEND:
+		return 2
+	case PROGRAM_CHANGE, CHANNEL_PRESSURE:
+		return 1
+	}
+
+	switch status {
+	case SYSTEM_EXCLUSIVE:
+		return -1
+	case TIME_CODE, SONG_SELECT:
+		return 1
+	case SONG_POSITION:
+		return 2
+	}
+
+	//tune request, undefined system common messages and realtime messages
+	return 0
+}
+
+//midiDecoder splits a raw midi byte stream into events.
+//It keeps track of running status and lets realtime bytes through
+//immediately, even when they arrive in the middle of another message.
+type midiDecoder struct {
+	r *bufio.Reader
+
+	runningStatus byte
+
+	//message currently being assembled
+	status  byte
+	data    []byte
+	inSysex bool
+}
+
+func newMidiDecoder(r io.Reader) *midiDecoder {
+	return &midiDecoder{r: bufio.NewReader(r)}
+}
+
+//next blocks until a complete event has been read
+func (d *midiDecoder) next() (midiEvent, error) {
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return midiEvent{}, err
+		}
+
+		if b >= TIMING_CLOCK {
+			//realtime, doesn't affect anything else
+			return midiEvent{status: b}, nil
+		}
+
+		if d.inSysex {
+			if b&0x80 == 0 {
+				d.data = append(d.data, b)
+				continue
+			}
+
+			//any status byte ends a system exclusive message. If it
+			//isn't the proper end byte we still want to look at it
+			//again as the start of the next message
+			if b != SYSTEM_END_EXCLUSIVE {
+				d.r.UnreadByte()
+			}
+			d.inSysex = false
+			return midiEvent{status: SYSTEM_EXCLUSIVE, data: d.take()}, nil
+		}
+
+		if b&0x80 != 0 {
+			if ev, ok := d.startMessage(b); ok {
+				return ev, nil
+			}
+			continue
+		}
+
+		//data byte
+		if d.status == 0 {
+			if d.runningStatus == 0 {
+				//stray data byte without any status to belong to
+				continue
+			}
+			d.status = d.runningStatus
+		}
+
+		d.data = append(d.data, b)
+		if len(d.data) == dataLength(d.status) {
+			ev := midiEvent{status: d.status, data: d.take()}
+			d.status = 0
+			return ev, nil
+		}
+	}
+}
+
+//startMessage handles a (non-realtime) status byte. Messages without any
+//data bytes are complete right away and are returned with ok == true.
+func (d *midiDecoder) startMessage(b byte) (ev midiEvent, ok bool) {
+	d.data = d.data[:0]
+
+	if b < SYSTEM {
+		d.runningStatus = b
+		d.status = b
+		return midiEvent{}, false
+	}
+
+	//system common messages cancel running status
+	d.runningStatus = 0
+	d.status = 0
+
+	switch b {
+	case SYSTEM_EXCLUSIVE:
+		d.inSysex = true
+		return midiEvent{}, false
+	case SYSTEM_END_EXCLUSIVE:
+		//end without a start, nothing to do
+		return midiEvent{}, false
+	}
+
+	if dataLength(b) == 0 {
+		return midiEvent{status: b}, true
+	}
+
+	d.status = b
+	return midiEvent{}, false
+}
+
+//take returns a copy of the data collected so far and resets the buffer
+func (d *midiDecoder) take() []byte {
+	ret := append([]byte{}, d.data...)
+	d.data = d.data[:0]
+	return ret
+}
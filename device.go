@@ -0,0 +1,335 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const reconnectInterval = time.Second
+
+//deviceInfo describes a raw midi port found in /dev
+type deviceInfo struct {
+	path        string
+	description string
+	//other paths to the same port, like /dev/midi1 for /dev/snd/midiC1D0
+	aliases []string
+}
+
+func (d deviceInfo) String() string {
+	if d.description == "" {
+		return d.path
+	}
+
+	return d.path + " (" + d.description + ")"
+}
+
+//listDevices returns every raw midi port currently present, both the old
+//style /dev/midiN and the ALSA /dev/snd/midiCxDy ones
+func listDevices() []deviceInfo {
+	sndPaths, _ := filepath.Glob("/dev/snd/midiC*D*")
+	paths, _ := filepath.Glob("/dev/midi*")
+
+	return devicesAt(append(sndPaths, paths...))
+}
+
+//devicesAt describes the ports at paths. On ALSA systems /dev/midiN is only
+//another way to get at /dev/snd/midiCND0, so every port is listed once, at
+//the first of its paths.
+func devicesAt(paths []string) []deviceInfo {
+	type port struct {
+		card, device int
+	}
+
+	ret := []deviceInfo{}
+	seen := map[port]int{}
+	for _, path := range paths {
+		card, device, ok := cardAndDevice(path)
+		if i, found := seen[port{card, device}]; ok && found {
+			ret[i].aliases = append(ret[i].aliases, path)
+			continue
+		}
+		if ok {
+			seen[port{card, device}] = len(ret)
+		}
+
+		ret = append(ret, deviceInfo{
+			path:        path,
+			description: describeDevice(card, device, ok),
+		})
+	}
+
+	return ret
+}
+
+//cardAndDevice tells which port of which sound card a device file is. ok is
+//false if its name doesn't say.
+func cardAndDevice(path string) (card, device int, ok bool) {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, "midiC") {
+		_, err := fmt.Sscanf(name, "midiC%dD%d", &card, &device)
+		return card, device, err == nil
+	}
+
+	//OSS emulation: /dev/midi is the first card, /dev/midiN the Nth
+	if name == "midi" {
+		return 0, 0, true
+	}
+	card, err := strconv.Atoi(strings.TrimPrefix(name, "midi"))
+
+	return card, 0, err == nil
+}
+
+//describeDevice finds a human readable name for a port in /proc/asound or
+//sysfs. Returns "" if nothing could be found.
+func describeDevice(card, device int, ok bool) string {
+	if !ok {
+		return ""
+	}
+
+	cardID := firstLine(fmt.Sprintf("/proc/asound/card%d/id", card))
+	if cardID == "" {
+		cardID = firstLine(fmt.Sprintf("/sys/class/sound/card%d/id", card))
+	}
+
+	//first line of this file is the name of the midi port itself
+	portName := firstLine(fmt.Sprintf("/proc/asound/card%d/midi%d", card, device))
+
+	switch {
+	case cardID != "" && portName != "":
+		return cardID + ": " + portName
+	case portName != "":
+		return portName
+	}
+
+	return cardID
+}
+
+func firstLine(file string) string {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.SplitN(string(contents), "\n", 2)[0])
+}
+
+//findDevice resolves what the user passed to -device: a full path, a name
+//in /dev or /dev/snd, or part of a device's description
+func findDevice(devices []deviceInfo, wanted string) (deviceInfo, bool) {
+	for _, d := range devices {
+		for _, path := range append([]string{d.path}, d.aliases...) {
+			if path == wanted || filepath.Base(path) == wanted {
+				return d, true
+			}
+		}
+	}
+
+	for _, d := range devices {
+		if strings.Contains(
+			strings.ToLower(d.description),
+			strings.ToLower(wanted),
+		) {
+			return d, true
+		}
+	}
+
+	return deviceInfo{}, false
+}
+
+//pickDevices returns the devices to open on startup. If nothing is wanted
+//and there are several devices, the user gets to choose.
+func pickDevices(wanted []string) []deviceInfo {
+	stdin := bufio.NewReader(os.Stdin)
+
+	for {
+		devices := listDevices()
+
+		if len(wanted) > 0 {
+			found := []deviceInfo{}
+			for _, w := range wanted {
+				if d, ok := findDevice(devices, w); ok {
+					found = append(found, d)
+				} else {
+					fmt.Println("Unable to find MIDI device", w+".")
+				}
+			}
+			if len(found) == len(wanted) {
+				return found
+			}
+		} else {
+			switch len(devices) {
+			case 0:
+				fmt.Println("Unable to find any midi devices in /dev.")
+			case 1:
+				return devices
+			default:
+				if chosen := chooseDevices(devices, stdin); len(chosen) > 0 {
+					return chosen
+				}
+				//rescan and ask again
+				continue
+			}
+		}
+
+		fmt.Println("Check connection to device, then press enter to try again, or ^C to cancel.")
+		stdin.ReadString('\n')
+	}
+}
+
+func chooseDevices(devices []deviceInfo, stdin *bufio.Reader) []deviceInfo {
+	fmt.Println("Found several MIDI devices:")
+	for i, d := range devices {
+		fmt.Printf("  [%d] %s\n", i+1, d)
+	}
+	fmt.Printf("Choose devices [1-%d, separated by commas], or press enter to scan again: ", len(devices))
+
+	line, err := stdin.ReadString('\n')
+	if err != nil {
+		//no way to ask, just go with the first one
+		fmt.Println()
+		return devices[:1]
+	}
+
+	ret := []deviceInfo{}
+	for _, field := range strings.Split(line, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 || n > len(devices) {
+			return nil
+		}
+		ret = append(ret, devices[n-1])
+	}
+
+	return ret
+}
+
+func printDevices() {
+	devices := listDevices()
+	if len(devices) == 0 {
+		fmt.Println("No MIDI devices found.")
+		return
+	}
+
+	for _, d := range devices {
+		fmt.Printf("%-20s %s\n", d.path, d.description)
+	}
+}
+
+func openDevice(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR, os.ModeDevice)
+}
+
+//rawInput reads from a midi device file, e.g. /dev/snd/midiC1D0
+type rawInput struct {
+	path string
+	//whether to wait for exactly this device after a disconnect, rather
+	//than taking whatever shows up first
+	onlyThisPath bool
+
+	dev  *os.File
+	midi *midiDecoder
+}
+
+func newRawInput(dev *os.File, path string, onlyThisPath bool) *rawInput {
+	return &rawInput{
+		path:         path,
+		onlyThisPath: onlyThisPath,
+		dev:          dev,
+		midi:         newMidiDecoder(dev),
+	}
+}
+
+func openRawInputs(devices []deviceInfo, onlyTheseDevices bool) []midiInput {
+	ret := []midiInput{}
+
+	for _, d := range devices {
+		dev, err := openDevice(d.path)
+		if err != nil {
+			fmt.Println("Unable to open MIDI device", d.String()+":", err)
+			os.Exit(1)
+		}
+
+		ret = append(ret, newRawInput(dev, d.path, onlyTheseDevices))
+
+		fmt.Println("# Found MIDI device", d, "#")
+	}
+
+	return ret
+}
+
+func (r *rawInput) next() (midiEvent, error) {
+	return r.midi.next()
+}
+
+func (r *rawInput) Write(b []byte) (int, error) {
+	return r.dev.Write(b)
+}
+
+func (r *rawInput) Close() error {
+	return r.dev.Close()
+}
+
+func (r *rawInput) name() string {
+	return filepath.Base(r.path)
+}
+
+func (r *rawInput) description() string {
+	return r.path
+}
+
+func (r *rawInput) reopen() midiInput {
+	dev, path := waitForDevice(r.path, r.onlyThisPath)
+	return newRawInput(dev, path, r.onlyThisPath)
+}
+
+//waitForDevice polls /dev until a midi device shows up and opens it.
+//The device at preferred is picked over others if it is present, and if
+//onlyPreferred is set no other device will do. Devices that are already
+//in use by another source are skipped.
+func waitForDevice(preferred string, onlyPreferred bool) (*os.File, string) {
+	for {
+		devices := listDevices()
+
+		candidates := []string{}
+		for _, d := range devices {
+			if d.path == preferred {
+				candidates = append([]string{d.path}, candidates...)
+			} else if !onlyPreferred && !inUse(d.path) {
+				candidates = append(candidates, d.path)
+			}
+		}
+
+		for _, path := range candidates {
+			dev, err := openDevice(path)
+			if err == nil {
+				return dev, path
+			}
+		}
+
+		time.Sleep(reconnectInterval)
+	}
+}
+
+func inUse(path string) bool {
+	for _, s := range sources {
+		s.mutex.Lock()
+		raw, isRaw := s.input.(*rawInput)
+		used := isRaw && s.connected && raw.path == path
+		s.mutex.Unlock()
+
+		if used {
+			return true
+		}
+	}
+
+	return false
+}
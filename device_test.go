@@ -0,0 +1,66 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCardAndDevice(t *testing.T) {
+	tests := []struct {
+		path         string
+		card, device int
+		ok           bool
+	}{
+		{"/dev/snd/midiC1D0", 1, 0, true},
+		{"/dev/snd/midiC2D3", 2, 3, true},
+		{"/dev/midi", 0, 0, true},
+		{"/dev/midi1", 1, 0, true},
+		{"/dev/midifoo", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		card, device, ok := cardAndDevice(test.path)
+		if ok != test.ok || ok && (card != test.card || device != test.device) {
+			t.Errorf("%s: got %d, %d, %v, want %d, %d, %v", test.path, card, device, ok, test.card, test.device, test.ok)
+		}
+	}
+}
+
+func TestDevicesAt(t *testing.T) {
+	devices := devicesAt([]string{
+		"/dev/snd/midiC1D0",
+		"/dev/snd/midiC1D1",
+		"/dev/midi",
+		"/dev/midi0",
+		"/dev/midi1",
+		"/dev/midifoo",
+	})
+
+	want := []struct {
+		path    string
+		aliases []string
+	}{
+		{"/dev/snd/midiC1D0", []string{"/dev/midi1"}},
+		{"/dev/snd/midiC1D1", nil},
+		{"/dev/midi", []string{"/dev/midi0"}},
+		{"/dev/midifoo", nil},
+	}
+
+	if len(devices) != len(want) {
+		t.Fatalf("got %v, want %d devices", devices, len(want))
+	}
+	for i, w := range want {
+		d := devices[i]
+		if d.path != w.path || fmt.Sprint(d.aliases) != fmt.Sprint(w.aliases) {
+			t.Errorf("device %d: got %s %v, want %s %v", i, d.path, d.aliases, w.path, w.aliases)
+		}
+	}
+
+	if d, ok := findDevice(devices, "midi1"); !ok || d.path != "/dev/snd/midiC1D0" {
+		t.Errorf("midi1: got %s, %v", d.path, ok)
+	}
+}
@@ -5,21 +5,25 @@
 package main
 
 import (
+	"fmt"
 	"sort"
-	"strconv"
-	"strings"
+	"time"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
 
 const (
-	lineSpacing        = 32   //px
-	lineThickness      = 3    //px
-	width              = 1600 //px
-	height             = 900  //px
-	halfWidth          = width / 2
-	halfHeight         = height / 2
-	fpsCap             = 300
+	lineSpacing   = 32   //px
+	lineThickness = 3    //px
+	width         = 1600 //px
+	height        = 900  //px
+	halfWidth     = width / 2
+	halfHeight    = height / 2
+	fpsCap        = 300
+	//the timeline starts right after the key signature, and the present is
+	//this far from the right edge
+	timelineLeft       = 450 //px
+	timelineRight      = 300 //px
 	sharpKeySignatures = "FCGDAEB"
 	flatKeySignatures  = "BEADGCF"
 )
@@ -54,7 +58,7 @@ const (
 var (
 	//set preferred font for U+E000-U+F8FF to some music font to render symbols properly
 	fontSize       = float32(lineSpacing * 5)
-	fontCodePoints = []rune("")
+	fontCodePoints = []rune("")
 	musicFont      rl.Font
 	noteX          = float32(500)
 	noteWidth      float32
@@ -62,17 +66,29 @@ var (
 	sustainStarTime   float32 = 1000000
 	sostenutoStarTime float32 = 1000000
 
-	keySigString = map[bool]string{
-		false: sharpKeySignatures,
-		true:  flatKeySignatures,
-	}[useFlats][:keySignature]
+	recordingSavedTime float32 = 1000000
+	//whether every file of the recording could be written the last time
+	recordingSaved bool
+
+	//what is being played, taken once at the start of every frame
+	frame stateSnapshot
+
 	keySignatureSettingOpen = false
+	pickerMode              = major
 
 	//change these for the background, foreground and music colors
 	//respectively
 	BGCOL = rl.Color{R: 0x00, G: 0x00, B: 0x00, A: 0xFF}
 	FGCOL = rl.Color{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
 	MUSIC = rl.Color{R: 0xFF, G: 0xCB, B: 0x00, A: 0xFF}
+
+	//used instead of MUSIC to tell several devices apart
+	sourceColors = []rl.Color{
+		MUSIC,
+		{R: 0x00, G: 0xC8, B: 0xFF, A: 0xFF},
+		{R: 0xFF, G: 0x4F, B: 0xA0, A: 0xFF},
+		{R: 0x6F, G: 0xE0, B: 0x4A, A: 0xFF},
+	}
 )
 
 func raylibWindow() {
@@ -80,87 +96,105 @@ func raylibWindow() {
 	rl.SetTraceLog(rl.LogNone)
 	rl.InitWindow(width, height, "Live Score")
 	rl.SetTargetFPS(fpsCap)
-
-	musicFont = rl.LoadFontEx(
-		"musicFont.otf",
-		int32(fontSize),
-		&fontCodePoints[0],
-		int32(len(fontCodePoints)),
-	)
-
-	noteWidth = rl.MeasureTextEx(musicFont, "", fontSize, 1).X
+	loadMusicFont()
 
 	for !rl.WindowShouldClose() {
 		//do this while not drawing -> better perf
-		//sort the active notes so we can draw note beams easier (in the
-		//future
-		activeNotes = unique(activeNotes)
-		sort.Slice(activeNotes, func(i, j int) bool {
-			return activeNotes[i] > activeNotes[j]
-		})
+		//the snapshot has the notes sorted, which the note head layout
+		//relies on (beams are worked out from the timeline's history)
+		frame = state.snapshot()
+		detector.update()
 
 		rl.BeginDrawing()
 		rl.ClearBackground(BGCOL)
 		draw()
 		rl.EndDrawing()
 
-		for _, note := range notesToClear {
-			activeNotes = remove(activeNotes, note)
-		}
-		notesToClear = []byte{}
+		updateSnapshot()
 	}
 
 	rl.CloseWindow()
 }
 
+//loadMusicFont needs a window to load the font into
+func loadMusicFont() {
+	musicFont = rl.LoadFontEx(
+		"musicFont.otf",
+		int32(fontSize),
+		&fontCodePoints[0],
+		int32(len(fontCodePoints)),
+	)
+
+	noteWidth = canvas.glyphWidth("")
+}
+
 func draw() {
+	drawScore()
+	//these need the mouse, so they are only in the window
+	drawKeyDetection()
+	drawSettings()
+	drawRecordButton()
+	drawSources()
+}
+
+//drawScore draws the music itself, onto whichever canvas is in use
+func drawScore() {
 	drawStaff()
+	drawKeyName()
+	drawChordSymbol()
+	drawRomanNumeral()
 	drawKeySignature()
-	drawNotes()
+	if showTimeline {
+		drawTimeline()
+	} else {
+		drawNotes()
+	}
 	drawPetalStatus()
-	drawSettings()
+}
+
+//colorFor returns the color to draw things coming from a device in
+func colorFor(source *midiSource) rl.Color {
+	if len(sources) < 2 || source == nil {
+		return MUSIC
+	}
+
+	return sourceColors[source.index%len(sourceColors)]
 }
 
 func drawStaff() {
 	for i := -2; i <= 2; i++ {
 		lineY := float32(trebleMiddleLineY + i*lineSpacing)
-		rl.DrawLineEx(
+		canvas.line(
 			rl.Vector2{X: 0, Y: lineY},
 			rl.Vector2{X: width, Y: lineY},
 			lineThickness,
 			FGCOL,
 		)
 	}
-	rl.DrawTextEx(
-		musicFont,
+	canvas.glyph(
 		"",
 		rl.Vector2{
 			X: 50,
 			Y: float32(trebleMiddleLineY) - 2*lineSpacing,
 		},
-		fontSize,
-		1,
 		FGCOL,
 	)
 
 	for i := -2; i <= 2; i++ {
 		lineY := float32(bassMiddleLineY + i*lineSpacing)
-		rl.DrawLineEx(
+		canvas.line(
 			rl.Vector2{X: 0, Y: lineY},
 			rl.Vector2{X: width, Y: lineY},
 			lineThickness,
 			FGCOL,
 		)
 	}
-	rl.DrawTextEx(
-		musicFont,
+	canvas.glyph(
 		"",
 		rl.Vector2{
 			X: 50,
 			Y: float32(bassMiddleLineY) - 2*lineSpacing,
 		},
-		fontSize,
-		1,
 		FGCOL,
 	)
 }
@@ -168,12 +202,14 @@ func drawStaff() {
 type keyoffsetMap = map[rune][2]int32
 
 func drawKeySignature() {
+	key := currentKeySettings()
+
 	symbol := ""
-	if useFlats {
+	if key.useFlats {
 		symbol = ""
 	}
 
-	symbolWidth := rl.MeasureTextEx(musicFont, symbol, fontSize, 1).X
+	symbolWidth := canvas.glyphWidth(symbol)
 
 	sharpOffsets := keyoffsetMap{
 		'C': {middleCY - octaveHeight, middleCY + octaveHeight},
@@ -200,31 +236,90 @@ func drawKeySignature() {
 		true:  flatOffsets,
 	}
 
-	for i, changedNote := range keySigString {
+	for i, changedNote := range key.sigString {
 		for staff := range []int{0, 1} {
-			rl.DrawTextEx(
-				musicFont,
+			canvas.glyph(
 				symbol,
 				rl.Vector2{
 					X: 150 + float32(i)*(symbolWidth+10),
-					Y: float32(offsets[useFlats][changedNote][staff]),
+					Y: float32(offsets[key.useFlats][changedNote][staff]),
 				},
-				fontSize,
-				1,
 				FGCOL,
 			)
 		}
 	}
 }
 
-func yOffsetFor(note byte) int32 {
-	//what note is it
-	noteStr := strings.Split(noteName(note, useFlats), "-")
-	name := noteStr[0][0]
+func drawKeyName() {
+	canvas.text(
+		currentKey().asciiName(),
+		50,
+		lineSpacing,
+		30,
+		FGCOL,
+	)
+}
+
+//drawKeyDetection has buttons to turn key detection on and off and to lock
+//the current key, and shows what it thinks the key is
+func drawKeyDetection() {
+	y := float32(lineSpacing + 40)
+	if textButton(50, y, 80, "auto", detector.isEnabled()) {
+		detector.setEnabled(!detector.isEnabled())
+	}
+	if textButton(140, y, 80, "lock", detector.isLocked()) {
+		detector.setLocked(!detector.isLocked())
+	}
+
+	if k, confidence, ok := detector.guess(); ok {
+		rl.DrawText(
+			fmt.Sprintf("sounds like %s (%.0f%%)", k.asciiName(), 100*confidence),
+			230,
+			int32(y)+4,
+			20,
+			FGCOL,
+		)
+	}
+}
+
+//drawChordSymbol writes the chord being played above the treble staff, the
+//way a lead sheet would
+func drawChordSymbol() {
+	c, ok := frame.chord()
+	if !ok {
+		return
+	}
+
+	canvas.text(
+		c.asciiName(),
+		noteX,
+		4*lineSpacing,
+		40,
+		MUSIC,
+	)
+}
+
+//drawRomanNumeral writes what the chord being played does in the current
+//key under the bass staff
+func drawRomanNumeral() {
+	numeral, ok := frame.romanNumeral()
+	if !ok {
+		return
+	}
+
+	canvas.text(
+		asciiSymbols.Replace(numeral),
+		noteX,
+		bassMiddleLineY+5*lineSpacing,
+		40,
+		MUSIC,
+	)
+}
 
-	//what octave is it
-	octave, _ := strconv.Atoi(noteStr[1])
-	octaveOffset := int32((4 - octave) * octaveHeight)
+func yOffsetFor(note byte) int32 {
+	//what note is it, in the current key
+	spelled := spell(note)
+	octaveOffset := int32((4 - spelled.octave) * octaveHeight)
 
 	//which actual note
 	baseY := map[byte]int32{
@@ -235,273 +330,660 @@ func yOffsetFor(note byte) int32 {
 		'G': trebleGY,
 		'A': trebleAY,
 		'B': trebleBY,
-	}[name]
+	}[spelled.letter]
 	return baseY + octaveOffset
 }
 
-//big yike... refactor this.
+//drawNotes draws the chord being held at noteX
 func drawNotes() {
-	shiftXFactor := 0
-	stemDown := false
+	layout := layoutHeldNotes(frame.notes)
 
-	for noteIdx, note := range activeNotes {
-		yOff := yOffsetFor(note)
+	for _, head := range layout.heads {
+		for _, y := range head.ledgerYs {
+			drawLedgerLineAt(head.ledgerX, y)
+		}
+		canvas.glyph(head.glyph, rl.Vector2{X: head.x, Y: head.y}, head.color)
+		if head.accidental != "" {
+			canvas.glyph(head.accidental, rl.Vector2{X: head.accidentalX, Y: head.y}, head.accidentalColor)
+		}
+	}
 
-		//......................in bravura
-		apparentNoteY := yOff + 2*lineSpacing
+	for _, stem := range layout.stems {
+		canvas.rectangle(
+			stem.x,
+			stem.top,
+			lineThickness,
+			stem.bottom-stem.top,
+			MUSIC,
+		)
+	}
 
-		//......................half octave
-		if noteIdx == 0 && yOff <= trebleBY {
-			stemDown = true
-		}
+	drawInterval()
+}
 
-		drawNoteHead(note, noteIdx, yOff, &shiftXFactor, &stemDown)
-		drawLedgerLines(yOff, apparentNoteY, shiftXFactor)
-		drawStem(stemDown, apparentNoteY, shiftXFactor)
-		drawAccidental(note, float32(yOff))
+//drawInterval names the interval next to the note heads when exactly two
+//notes are sounding
+func drawInterval() {
+	i, ok := frame.interval()
+	if !ok {
+		return
 	}
+
+	const textSize = 30
+
+	//halfway between the two heads, to the right of them (and of a second
+	//head shifted to the side)
+	top := yOffsetFor(frame.notes[0].note) + 2*lineSpacing
+	bottom := yOffsetFor(frame.notes[1].note) + 2*lineSpacing
+
+	canvas.text(
+		i.shortName(),
+		noteX+2*noteWidth+lineSpacing,
+		float32((top+bottom)/2-textSize/2),
+		textSize,
+		FGCOL,
+	)
 }
 
-func drawNoteHead(
-	note byte,
-	noteIdx int,
-	yOff int32,
-	shiftXFactor *int,
-	stemDown *bool,
-) {
-	hasPrev := noteIdx > 0
-	hasNext := noteIdx < len(activeNotes)-1
-	shouldToggleShift := false
+//drawTimeline draws what was played over the last few measures, scrolling
+//to the left, with the present timelineRight from the right edge
+func drawTimeline() {
+	now := time.Now()
+	window := timelineWindow()
+	from := now.Add(-window)
+
+	const nowX = width - timelineRight
+	pixelsPerSecond := (width - timelineRight - timelineLeft) / float32(window.Seconds())
+	xAt := func(t time.Time) float32 {
+		return nowX - float32(now.Sub(t).Seconds())*pixelsPerSecond
+	}
+
+	top := float32(trebleMiddleLineY - 2*lineSpacing)
+	bottom := float32(bassMiddleLineY + 2*lineSpacing)
+	for _, at := range barlines(frame.firstOnset, from, now) {
+		canvas.line(
+			rl.Vector2{X: xAt(at), Y: top},
+			rl.Vector2{X: xAt(at), Y: bottom},
+			lineThickness,
+			FGCOL,
+		)
+	}
+
+	//keys that are still down last until now
+	played := frame.history
+	for _, held := range frame.pressed() {
+		played = append(played, playedNote{
+			note:     held.note,
+			channel:  held.channel,
+			velocity: held.velocity,
+			onset:    held.onset,
+			release:  now,
+			source:   held.source,
+		})
+	}
 
-	couldCollide := false
-	closeEnough := false
+	//middle C and up go on the treble staff
+	treble, bass := []playedNote{}, []playedNote{}
+	for _, p := range played {
+		if p.note >= 60 {
+			treble = append(treble, p)
+		} else {
+			bass = append(bass, p)
+		}
+	}
 
-	if hasNext {
-		next := activeNotes[noteIdx+1]
-		visualDistance := yOffsetFor(next) - yOff
+	//both staves snap to the same grid
+	g := newGrid(frame.firstOnset, played)
 
-		couldCollide = isOnLine(note) != isOnLine(next)
-		closeEnough = visualDistance <= lineSpacing/2
+	staves := []struct {
+		notes   []playedNote
+		middleY int32
+	}{
+		{treble, trebleMiddleLineY},
+		{bass, bassMiddleLineY},
 	}
 
-	if hasPrev {
-		prev := activeNotes[noteIdx-1]
-		visualDistance := yOff - yOffsetFor(prev)
-		if visualDistance >= 4*lineSpacing {
-			if yOff > middleCY {
-				*stemDown = false
+	for _, staff := range staves {
+		chords := notate(staff.notes, g)
+
+		xs := make([]float32, len(chords))
+		visible := make([]bool, len(chords))
+		for i, c := range chords {
+			at := tickTime(frame.firstOnset, c.start)
+			xs[i] = xAt(at)
+			//scrolled past the key signature
+			visible[i] = !at.Before(from)
+		}
+
+		beams := make([]*beam, len(chords))
+		for _, group := range beamGroups(chords) {
+			//half scrolled away, the rest get flags
+			if !visible[group[0]] {
+				continue
 			}
 
-			shouldToggleShift = false
-			*shiftXFactor = 0
-		} else {
-			//only update these if we're less than
-			//an octave away
-			couldCollide = isOnLine(note) != isOnLine(prev)
-			closeEnough = visualDistance <= lineSpacing/2
+			b := beamFor(chords, xs, group, staff.middleY)
+			for _, i := range group {
+				beams[i] = &b
+			}
+			drawBeams(chords, xs, group, b)
+		}
+
+		for i, c := range chords {
+			if !visible[i] {
+				continue
+			}
+
+			stemDown := drawNotatedChord(c, xs[i], staff.middleY, beams[i])
+			if i+1 < len(chords) {
+				drawTies(c, xs[i], xs[i+1], stemDown)
+			}
 		}
 	}
+}
 
-	if couldCollide && closeEnough {
-		shouldToggleShift = true
+//SMuFL glyphs for the note values, by base value
+var (
+	noteHeadGlyphs = map[int]string{
+		1: "",
+		2: "",
+	}
+	restGlyphs = map[int]string{
+		1:  "",
+		2:  "",
+		4:  "",
+		8:  "",
+		16: "",
+		32: "",
+	}
+	flagUpGlyphs = map[int]string{
+		8:  "",
+		16: "",
+		32: "",
 	}
+	flagDownGlyphs = map[int]string{
+		8:  "",
+		16: "",
+		32: "",
+	}
+)
 
-	if shouldToggleShift {
-		//toggle between 0 and 1
-		*shiftXFactor = 1 - *shiftXFactor
+//drawNotatedChord draws a chord or rest of the timeline at x, and returns
+//which way the stem points. Beamed chords get their stem up to the beam
+//instead of flags.
+func drawNotatedChord(c notatedChord, x float32, staffMiddleY int32, b *beam) (stemDown bool) {
+	if c.isRest() {
+		drawRest(c.value, x, staffMiddleY)
+		return false
 	}
 
-	if noteIdx == 0 && yOff <= trebleBY {
-		*shiftXFactor = 1
+	notes := append([]playedNote{}, c.notes...)
+	sort.Slice(notes, func(i, j int) bool {
+		return notes[i].note > notes[j].note
+	})
+
+	highestY, lowestY := chordSpan(c)
+	//the stem goes on the side with more room, away from whichever end is
+	//further from the middle line
+	stemDown = staffMiddleY-highestY > lowestY-staffMiddleY
+	if b != nil {
+		stemDown = b.stemDown
 	}
 
-	rl.DrawTextEx(
-		musicFont,
-		"",
-		rl.Vector2{
-			X: noteX + (float32(*shiftXFactor) * (noteWidth - lineThickness)),
-			Y: float32(yOff),
-		},
-		fontSize,
-		1,
-		MUSIC,
-	)
+	glyph, ok := noteHeadGlyphs[c.value.base]
+	if !ok {
+		glyph = ""
+	}
+
+	ys := make([]int32, len(notes))
+	for i, p := range notes {
+		ys[i] = yOffsetFor(p.note) + 2*lineSpacing
+	}
+	//seconds are drawn side by side
+	displaced := displacedHeads(ys, stemDown)
+	noteNumbers := make([]byte, len(notes))
+	for i, p := range notes {
+		noteNumbers[i] = p.note
+	}
+	accidentalXs := placeAccidentals(noteNumbers, leftmostHeadX(x, displaced, stemDown))
+
+	//dots go right of everything
+	dotX := x + noteWidth + lineSpacing/3
+	for _, d := range displaced {
+		if d && !stemDown {
+			dotX += noteWidth
+			break
+		}
+	}
+
+	for i, p := range notes {
+		yOff := yOffsetFor(p.note)
+		headX := headXFor(x, displaced[i], stemDown)
+
+		drawLedgerLines(headX, yOff, ys[i])
+		canvas.glyph(
+			glyph,
+			rl.Vector2{X: headX, Y: float32(yOff)},
+			colorFor(p.source),
+		)
+		drawAccidental(p.note, p.source, accidentalXs[i], float32(yOff))
+
+		//dots go in a space, so notes on a line get theirs above
+		dotY := ys[i]
+		if isOnLine(p.note) {
+			dotY -= lineSpacing / 2
+		}
+		drawDots(c.value, dotX, dotY, colorFor(p.source))
+	}
+
+	if c.value.base == 1 {
+		return stemDown
+	}
+
+	var endY int32
+	switch {
+	case b != nil:
+		endY = int32(b.yAt(stemXFor(x, stemDown)))
+	case stemDown:
+		endY = naturalStemEnd(lowestY, true, staffMiddleY)
+	default:
+		endY = naturalStemEnd(highestY, false, staffMiddleY)
+	}
+	stemX := drawChordStem(x, stemDown, highestY, lowestY, endY)
+
+	//the beam takes care of the rest
+	if b != nil {
+		return stemDown
+	}
+
+	flags := flagUpGlyphs
+	if stemDown {
+		flags = flagDownGlyphs
+	}
+	if flag, ok := flags[c.value.base]; ok {
+		canvas.glyph(
+			flag,
+			rl.Vector2{X: stemX, Y: float32(endY - 2*lineSpacing)},
+			MUSIC,
+		)
+	}
+
+	if c.value.triplet {
+		labelY := endY - lineSpacing
+		if stemDown {
+			labelY = endY + lineSpacing/4
+		}
+		canvas.text("3", stemX-5, float32(labelY), 24, FGCOL)
+	}
+
+	return stemDown
 }
 
-func drawLedgerLineAt(y float32, shiftXFactor int) {
-	rl.DrawRectangle(
-		int32(noteX-lineSpacing/2+float32(shiftXFactor)*noteWidth),
-		int32(y-lineThickness/2),
-		int32(noteWidth+lineSpacing),
+//chordSpan returns where the highest and lowest note heads of a chord are
+func chordSpan(c notatedChord) (highestY, lowestY int32) {
+	highest, lowest := c.notes[0].note, c.notes[0].note
+	for _, p := range c.notes {
+		if p.note > highest {
+			highest = p.note
+		}
+		if p.note < lowest {
+			lowest = p.note
+		}
+	}
+
+	return yOffsetFor(highest) + 2*lineSpacing, yOffsetFor(lowest) + 2*lineSpacing
+}
+
+//stemXFor is where the stem of a note head at x goes: on the right for up
+//stems, on the left for down stems
+func stemXFor(x float32, stemDown bool) float32 {
+	if stemDown {
+		return x
+	}
+
+	return x + noteWidth - lineThickness
+}
+
+//naturalStemEnd is where a stem without a beam ends: three and a half
+//spaces from the note, but at least reaching the middle line
+func naturalStemEnd(noteY int32, stemDown bool, staffMiddleY int32) int32 {
+	const stemLength = int32(3.5 * lineSpacing)
+
+	if stemDown {
+		if noteY+stemLength < staffMiddleY {
+			return staffMiddleY
+		}
+		return noteY + stemLength
+	}
+
+	if noteY-stemLength > staffMiddleY {
+		return staffMiddleY
+	}
+	return noteY - stemLength
+}
+
+//drawChordStem draws a stem through every note head of a chord, from the
+//note furthest away from endY to endY, and returns where it is
+func drawChordStem(x float32, stemDown bool, highestY, lowestY, endY int32) (stemX float32) {
+	stemX = stemXFor(x, stemDown)
+
+	top, bottom := endY, lowestY
+	if stemDown {
+		top, bottom = highestY, endY
+	}
+
+	canvas.rectangle(
+		stemX,
+		float32(top),
 		lineThickness,
+		float32(bottom-top),
 		MUSIC,
 	)
+
+	return stemX
+}
+
+const (
+	beamThickness = lineSpacing / 2
+	beamGap       = lineSpacing / 4
+	//beamed stems are never shorter than this, from the note nearest to
+	//the beam
+	minStemLength = 2.5 * lineSpacing
+	//a beam rises or falls at most this much from its first note to its last
+	maxBeamRise = lineSpacing
+)
+
+//beam is the line the stems of a beamed group end on, which is the outer
+//edge of the first beam
+type beam struct {
+	stemDown bool
+	x, y     float32
+	slope    float32
+}
+
+func (b beam) yAt(x float32) float32 {
+	return b.y + (x-b.x)*b.slope
 }
 
-func drawLedgerLines(yOff, apparentNoteY int32, shiftXFactor int) {
-	ledgersNeededAbove := apparentNoteY <= trebleMiddleLineY-3*lineSpacing
-	if ledgersNeededAbove {
-		for y := float32(trebleMiddleLineY - 3*lineSpacing); y >= float32(apparentNoteY); y -= lineSpacing {
-			drawLedgerLineAt(y, shiftXFactor)
+//beamFor places the beam of a group. The note furthest from the middle line
+//decides which way the stems go, the beam follows the first and last notes
+//without getting too steep, and is then moved away from the notes until
+//every stem is long enough.
+func beamFor(chords []notatedChord, xs []float32, group []int, staffMiddleY int32) beam {
+	furthest := int32(0)
+	for _, i := range group {
+		highestY, lowestY := chordSpan(chords[i])
+		for _, y := range []int32{highestY, lowestY} {
+			if abs(int(y-staffMiddleY)) > abs(int(furthest)) {
+				furthest = y - staffMiddleY
+			}
+		}
+	}
+	stemDown := furthest < 0
+
+	//the note of a chord the beam comes closest to
+	nearest := func(i int) int32 {
+		highestY, lowestY := chordSpan(chords[i])
+		if stemDown {
+			return lowestY
 		}
+		return highestY
 	}
 
-	if yOff == middleCY {
-		drawLedgerLineAt(float32(halfHeight), shiftXFactor)
+	first, last := group[0], group[len(group)-1]
+	b := beam{
+		stemDown: stemDown,
+		x:        stemXFor(xs[first], stemDown),
+		y:        float32(naturalStemEnd(nearest(first), stemDown, staffMiddleY)),
+	}
+
+	rise := float32(naturalStemEnd(nearest(last), stemDown, staffMiddleY)) - b.y
+	if rise > maxBeamRise {
+		rise = maxBeamRise
+	}
+	if rise < -maxBeamRise {
+		rise = -maxBeamRise
 	}
+	if width := stemXFor(xs[last], stemDown) - b.x; width > 0 {
+		b.slope = rise / width
+	}
+
+	for _, i := range group {
+		y := b.yAt(stemXFor(xs[i], stemDown))
+		noteY := float32(nearest(i))
 
-	ledgersNeededBelow := apparentNoteY >= bassMiddleLineY+3*lineSpacing
-	if ledgersNeededBelow {
-		for y := float32(bassMiddleLineY + 3*lineSpacing); y <= float32(apparentNoteY); y += lineSpacing {
-			drawLedgerLineAt(y, shiftXFactor)
+		if stemDown {
+			if short := noteY + minStemLength - y; short > 0 {
+				b.y += short
+			}
+		} else {
+			if short := y - (noteY - minStemLength); short > 0 {
+				b.y -= short
+			}
 		}
 	}
+
+	return b
 }
 
-func drawStem(stemDown bool, apparentNoteY int32, shiftXFactor int) {
-	stemYOffset := int32(3.5 * lineSpacing)
-	stemLength := int32(3.5*lineSpacing + shiftXFactor*(0.25*lineSpacing))
+//drawBeams draws the first beam across the whole group, and further beams
+//between neighbouring notes that are short enough for them. Notes without
+//such a neighbour get a short stub instead.
+func drawBeams(chords []notatedChord, xs []float32, group []int, b beam) {
+	//further beams are stacked towards the notes
+	direction := float32(1)
+	if b.stemDown {
+		direction = -1
+	}
 
-	if stemDown {
-		stemYOffset = 0
+	segment := func(level int, fromX, toX float32) {
+		offset := direction * (float32(level)*(beamThickness+beamGap) + beamThickness/2)
+		canvas.line(
+			rl.Vector2{X: fromX, Y: b.yAt(fromX) + offset},
+			rl.Vector2{X: toX, Y: b.yAt(toX) + offset},
+			beamThickness,
+			MUSIC,
+		)
+	}
+
+	levels := func(n int) int {
+		if n < 0 || n >= len(group) {
+			return 0
+		}
+		return chords[group[n]].value.beamLevels()
 	}
 
-	//change stem length
-	//TODO
+	for n, i := range group {
+		x := stemXFor(xs[i], b.stemDown)
+
+		for level := 0; level < levels(n); level++ {
+			switch {
+			case levels(n+1) > level:
+				next := stemXFor(xs[group[n+1]], b.stemDown)
+				segment(level, x, next+lineThickness)
+			case levels(n-1) > level:
+				//drawn together with the previous note
+			case n == 0:
+				segment(level, x, x+noteWidth)
+			default:
+				segment(level, x-noteWidth, x+lineThickness)
+			}
+		}
+	}
 
-	rl.DrawRectangle(
-		int32(noteX+noteWidth-lineThickness),
-		apparentNoteY-stemYOffset,
-		lineThickness,
-		stemLength,
-		MUSIC,
-	)
+	for _, i := range group {
+		if !chords[i].value.triplet {
+			continue
+		}
+
+		middle := (stemXFor(xs[group[0]], b.stemDown) + stemXFor(xs[group[len(group)-1]], b.stemDown)) / 2
+		labelY := b.yAt(middle) - lineSpacing
+		if b.stemDown {
+			labelY = b.yAt(middle) + lineSpacing/4
+		}
+		canvas.text("3", middle-5, labelY, 24, FGCOL)
+		break
+	}
 }
 
-func drawAccidental(note byte, yOff float32) {
-	noteStr := strings.Split(noteName(note, useFlats), "-")
-	name := rune(noteStr[0][0])
+func drawRest(v noteValue, x float32, staffMiddleY int32) {
+	//whole rests hang from the line above the middle one
+	y := staffMiddleY
+	if v.base == 1 {
+		y -= lineSpacing
+	}
 
-	keyAffectsCurrentNote := strings.ContainsRune(
-		keySigString,
-		name,
+	canvas.glyph(
+		restGlyphs[v.base],
+		rl.Vector2{X: x, Y: float32(y - 2*lineSpacing)},
+		FGCOL,
 	)
+	drawDots(v, x+noteWidth+lineSpacing/3, staffMiddleY-lineSpacing/2, FGCOL)
 
-	if strings.ContainsRune(noteStr[0], '♮') {
-		if keyAffectsCurrentNote {
-			//draw natural
-			rl.DrawTextEx(
-				musicFont,
-				"",
-				rl.Vector2{
-					X: noteX - lineSpacing*1.5,
-					Y: yOff,
-				},
-				fontSize,
-				1,
-				MUSIC,
-			)
+	if v.triplet {
+		canvas.text("3", x+noteWidth/2-5, float32(staffMiddleY-3*lineSpacing), 24, FGCOL)
+	}
+}
+
+func drawDots(v noteValue, x float32, y int32, color rl.Color) {
+	for i := 0; i < v.dots; i++ {
+		canvas.circle(
+			x+float32(i)*lineSpacing/2,
+			float32(y),
+			lineSpacing/6,
+			color,
+		)
+	}
+}
+
+//drawTies connects the notes of a chord that are held on to the same notes
+//at nextX, curving away from the stem
+func drawTies(c notatedChord, x, nextX float32, stemDown bool) {
+	const segments = 8
+
+	direction := float32(1)
+	if stemDown {
+		direction = -1
+	}
+
+	for i, p := range c.notes {
+		if !c.tiedToNext[i] {
+			continue
 		}
-	} else {
-		if !keyAffectsCurrentNote {
-			if !useFlats {
-				//draw sharp
-				rl.DrawTextEx(
-					musicFont,
-					"",
-					rl.Vector2{
-						X: noteX - lineSpacing*1.5,
-						Y: yOff,
-					},
-					fontSize,
-					1,
-					MUSIC,
-				)
-			} else {
-				//draw flat
-				rl.DrawTextEx(
-					musicFont,
-					"",
-					rl.Vector2{
-						X: noteX - lineSpacing*1.5,
-						Y: yOff,
-					},
-					fontSize,
-					1,
-					MUSIC,
-				)
+
+		startX := x + noteWidth
+		width := nextX - startX
+		y := float32(yOffsetFor(p.note)+2*lineSpacing) + direction*lineSpacing/2
+
+		//a flat parabola through both ends
+		point := func(i int) rl.Vector2 {
+			t := float32(i) / segments
+			return rl.Vector2{
+				X: startX + t*width,
+				Y: y + direction*4*t*(1-t)*lineSpacing/2,
 			}
 		}
+
+		for i := 0; i < segments; i++ {
+			canvas.line(point(i), point(i+1), 2, colorFor(p.source))
+		}
+	}
+}
+
+//noteHeadStyle tells pressed keys apart from notes that only ring because of
+//a pedal: the sostenuto pedal makes them hollow, the sustain pedal faded
+func noteHeadStyle(held heldNote) (string, rl.Color) {
+	color := colorFor(held.source)
+
+	switch {
+	case held.captured:
+		return "", color
+	case held.released:
+		return "", rl.Fade(color, 0.4)
+	}
+
+	return "", color
+}
+
+//drawLedgerLineAt draws a ledger line for a note head at x
+func drawLedgerLineAt(x, y float32) {
+	canvas.rectangle(
+		x-lineSpacing/2,
+		y-lineThickness/2,
+		noteWidth+lineSpacing,
+		lineThickness,
+		MUSIC,
+	)
+}
+
+func drawLedgerLines(x float32, yOff, apparentNoteY int32) {
+	for _, y := range ledgerLinesFor(yOff, apparentNoteY) {
+		drawLedgerLineAt(x, y)
+	}
+}
+
+//drawAccidental draws the accidental of a note at x, as placed by
+//placeAccidentals, if it needs one
+func drawAccidental(note byte, source *midiSource, x, yOff float32) {
+	if glyph, ok := accidentalFor(note); ok {
+		canvas.glyph(
+			glyph,
+			rl.Vector2{
+				X: x,
+				Y: yOff,
+			},
+			colorFor(source),
+		)
 	}
 }
 
 func drawPetalStatus() {
 	//draw sustain
-	if sustainPercent < 0.2 {
+	if frame.sustain.percent() < 0.2 {
 		if sustainStarTime < 0.25 /*seconds*/ {
 			sustainStarTime += rl.GetFrameTime()
 
-			rl.DrawTextEx(
-				musicFont,
+			canvas.glyph(
 				"",
 				rl.Vector2{X: lineSpacing / 2, Y: height - fontSize},
-				fontSize,
-				1,
-				MUSIC,
+				colorFor(frame.sustain.source),
 			)
 		}
 	} else {
 		//pedal pressed
 		sustainStarTime = 0
-		rl.DrawTextEx(
-			musicFont,
+		canvas.glyph(
 			"",
 			rl.Vector2{X: lineSpacing / 2, Y: height - fontSize},
-			fontSize,
-			1,
-			rl.Fade(MUSIC, sustainPercent),
+			rl.Fade(colorFor(frame.sustain.source), frame.sustain.percent()),
 		)
 	}
 
 	//draw sostenuto
-	if sostenutoPercent < 0.2 {
+	if frame.sostenuto.percent() < 0.2 {
 		if sostenutoStarTime < 0.25 /*seconds*/ {
 			//pedal released -> blink pedal "star"
 			sostenutoStarTime += rl.GetFrameTime()
 
-			rl.DrawTextEx(
-				musicFont,
+			canvas.glyph(
 				"",
 				rl.Vector2{
-					X: lineSpacing + rl.MeasureTextEx(
-						musicFont,
-						"",
-						fontSize,
-						1).X,
+					X: lineSpacing + canvas.glyphWidth(""),
 					Y: height - fontSize,
 				},
-				fontSize,
-				1,
-				MUSIC,
+				colorFor(frame.sostenuto.source),
 			)
 		}
 	} else {
 		//pedal pressed
 		sostenutoStarTime = 0
-		rl.DrawTextEx(
-			musicFont,
+		canvas.glyph(
 			"",
 			rl.Vector2{
-				X: lineSpacing + rl.MeasureTextEx(
-					musicFont,
-					"",
-					fontSize,
-					1).X,
+				X: lineSpacing + canvas.glyphWidth(""),
 				Y: height - fontSize,
 			},
-			fontSize,
-			1,
-			rl.Fade(MUSIC, sostenutoPercent),
+			rl.Fade(colorFor(frame.sostenuto.source), frame.sostenuto.percent()),
 		)
 	}
 }
@@ -533,10 +1015,11 @@ func drawSettings() {
 		})
 	if mouseInsideButton && rl.IsMouseButtonPressed(rl.MouseLeftButton) {
 		keySignatureSettingOpen = !keySignatureSettingOpen
+		pickerMode = currentKey().mode
 	}
 
 	sign := ""
-	if useFlats {
+	if useFlats() {
 		sign = ""
 	}
 	rl.DrawTextEx(
@@ -574,99 +1057,23 @@ func drawSettings() {
 	)
 
 	if keySignatureSettingOpen {
-		//minus button
-		rl.DrawRectangle(
-			int32(width-buttonSize*1),
-			buttonSize,
-			buttonSize,
-			buttonSize,
-			rl.Gray)
-		rl.DrawRectangleLines(
-			int32(width-buttonSize*1),
-			buttonSize,
-			buttonSize,
-			buttonSize,
-			BGCOL)
-
-		//minus sign
-		rl.DrawRectangle(
-			int32(width-buttonSize*1+buttonSize/4),
-			1.5*buttonSize-2,
-			buttonSize/2,
-			4,
-			BGCOL)
-
-		mouseInsideButton = rl.CheckCollisionPointRec(
-			rl.GetMousePosition(),
-			rl.Rectangle{
-				X:      width - buttonSize*1,
-				Y:      buttonSize,
-				Width:  buttonSize,
-				Height: buttonSize,
-			},
-		)
+		drawKeyPicker()
+	}
+	//end key signature option
 
-		if mouseInsideButton && rl.IsMouseButtonPressed(rl.MouseLeftButton) {
-			keySignature--
-			if keySignature < 0 {
-				keySignature = 0
-			}
-			keySigString = map[bool]string{
-				false: sharpKeySignatures,
-				true:  flatKeySignatures,
-			}[useFlats][:keySignature]
-		}
+	drawSpellingButton()
+}
 
-		//plus button
-		rl.DrawRectangle(
-			int32(width-buttonSize*2),
-			buttonSize,
-			buttonSize,
-			buttonSize,
-			rl.Gray)
-		rl.DrawRectangleLines(
-			int32(width-buttonSize*2),
-			buttonSize,
-			buttonSize,
-			buttonSize,
-			BGCOL)
-		//plus sign
-		rl.DrawRectangle(
-			int32(width-buttonSize*2+buttonSize/4),
-			1.5*buttonSize-2,
-			buttonSize/2,
-			4,
-			BGCOL)
-		rl.DrawRectangle(
-			int32(width-buttonSize*1.5-2),
-			1.25*buttonSize,
-			4,
-			buttonSize/2,
-			BGCOL)
-
-		mouseInsideButton = rl.CheckCollisionPointRec(
-			rl.GetMousePosition(),
-			rl.Rectangle{
-				X:      width - buttonSize*2,
-				Y:      buttonSize,
-				Width:  buttonSize,
-				Height: buttonSize,
-			},
-		)
-		if mouseInsideButton && rl.IsMouseButtonPressed(rl.MouseLeftButton) {
-			keySignature++
-			if keySignature > 7 {
-				keySignature = 7
-			}
-			keySigString = map[bool]string{
-				false: sharpKeySignatures,
-				true:  flatKeySignatures,
-			}[useFlats][:keySignature]
-		}
+//drawSpellingButton draws the button that switches between sharps and flats.
+//It is only there when that does something: in keys without accidentals,
+//and in keys that can be written either way, like F♯ and G♭ major.
+func drawSpellingButton() {
+	const buttonSize = 2 * lineSpacing
+
+	if !currentKey().hasEnharmonic() && currentKey().fifths() != 0 {
+		return
 	}
-	//end key signature option
 
-	//draw flat/sharp button
 	rl.DrawRectangle(
 		int32(width-buttonSize*2),
 		0,
@@ -687,7 +1094,7 @@ func drawSettings() {
 		BGCOL,
 	)
 
-	mouseInsideButton = rl.CheckCollisionPointRec(
+	mouseInsideButton := rl.CheckCollisionPointRec(
 		rl.GetMousePosition(),
 		rl.Rectangle{
 			X:      width - buttonSize*2,
@@ -697,18 +1104,21 @@ func drawSettings() {
 		})
 	if mouseInsideButton && rl.IsMouseButtonPressed(rl.MouseLeftButton) {
 		keySignatureSettingOpen = false
-		useFlats = !useFlats
-		keySigString = map[bool]string{
-			false: sharpKeySignatures,
-			true:  flatKeySignatures,
-		}[useFlats][:keySignature]
+		//keys without accidentals just switch what the notes outside
+		//the key look like
+		if currentKey().fifths() == 0 {
+			setUseFlats(!useFlats())
+		} else {
+			setKey(currentKey().enharmonic())
+		}
 	}
 
 	colors := map[bool]rl.Color{
 		false: BGCOL,
 		true:  MUSIC,
 	}
-	sharpColor, flatColor := colors[!useFlats], colors[useFlats]
+	flats := useFlats()
+	sharpColor, flatColor := colors[!flats], colors[flats]
 	rl.DrawTextEx(
 		musicFont,
 		"",
@@ -731,5 +1141,152 @@ func drawSettings() {
 		1,
 		flatColor,
 	)
-	//end flat/sharp button
+}
+
+//drawKeyPicker lists the modes on the left and every key of the chosen mode
+//on the right, from 7 flats to 7 sharps
+func drawKeyPicker() {
+	const columnWidth = 180
+	left := float32(width - 2*columnWidth)
+	top := float32(2*lineSpacing + lineSpacing)
+
+	rl.DrawRectangle(
+		int32(left),
+		int32(top),
+		2*columnWidth,
+		15*pickerRowHeight,
+		rl.Gray,
+	)
+
+	for m, name := range modeNames {
+		y := top + float32(m*pickerRowHeight)
+		if textButton(left, y, columnWidth, name, mode(m) == pickerMode) {
+			pickerMode = mode(m)
+		}
+	}
+
+	for fifths := -7; fifths <= 7; fifths++ {
+		k := keyFromFifths(fifths, pickerMode)
+		y := top + float32((fifths+7)*pickerRowHeight)
+
+		if textButton(left+columnWidth, y, columnWidth, k.asciiName(), k == currentKey()) {
+			setKey(k)
+			//a key picked by hand shouldn't be overridden
+			detector.setLocked(true)
+		}
+	}
+}
+
+const pickerRowHeight = 28
+
+//textButton draws a button with a label, e.g. a row of the key picker, and
+//reports whether it was clicked
+func textButton(x, y, w float32, text string, selected bool) bool {
+	bounds := rl.Rectangle{X: x, Y: y, Width: w, Height: pickerRowHeight}
+
+	if selected {
+		rl.DrawRectangleRec(bounds, MUSIC)
+	} else {
+		rl.DrawRectangleRec(bounds, rl.Gray)
+	}
+	rl.DrawRectangleLines(
+		int32(x),
+		int32(y),
+		int32(w),
+		pickerRowHeight,
+		BGCOL,
+	)
+	rl.DrawText(text, int32(x)+8, int32(y)+4, 20, BGCOL)
+
+	return rl.CheckCollisionPointRec(rl.GetMousePosition(), bounds) &&
+		rl.IsMouseButtonPressed(rl.MouseLeftButton)
+}
+
+//drawRecordButton saves the recording when clicked. Only shown when there is
+//something to save it to, e.g. with -record or -musicxml.
+func drawRecordButton() {
+	const buttonSize = 2 * lineSpacing
+
+	if recording == nil {
+		return
+	}
+
+	rl.DrawRectangle(
+		int32(width-buttonSize*3),
+		0,
+		buttonSize,
+		buttonSize,
+		rl.Gray)
+	rl.DrawRectangleLines(
+		int32(width-buttonSize*3),
+		0,
+		buttonSize,
+		buttonSize,
+		BGCOL)
+	rl.DrawCircle(
+		int32(width-buttonSize*2.5),
+		buttonSize/2,
+		buttonSize/4,
+		rl.Red,
+	)
+
+	mouseInsideButton := rl.CheckCollisionPointRec(
+		rl.GetMousePosition(),
+		rl.Rectangle{
+			X:      width - buttonSize*3,
+			Y:      0,
+			Width:  buttonSize,
+			Height: buttonSize,
+		})
+	if mouseInsideButton && rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		recordingSaved = saveRecording()
+		recordingSavedTime = 0
+	}
+
+	//show that something happened for a moment, and whether it worked (the
+	//terminal says which file failed)
+	if recordingSavedTime < 2 /*seconds*/ {
+		recordingSavedTime += rl.GetFrameTime()
+
+		text, color := "Saved", FGCOL
+		if !recordingSaved {
+			text, color = "Not saved", rl.Red
+		}
+		const textSize = 20
+		rl.DrawText(
+			text,
+			int32(width-buttonSize*2.5)-rl.MeasureText(text, textSize)/2,
+			buttonSize+4,
+			textSize,
+			color,
+		)
+	}
+}
+
+//drawSources shows which color belongs to which device, and which devices
+//are currently disconnected
+func drawSources() {
+	const textSize = 20
+
+	y := int32(height - textSize - lineSpacing/2)
+	for i := len(sources) - 1; i >= 0; i-- {
+		source := sources[i]
+
+		text := source.name()
+		if !source.isConnected() {
+			text += " (disconnected)"
+		} else if len(sources) < 2 {
+			//nothing worth showing for a single connected device
+			continue
+		}
+
+		rl.DrawText(
+			text,
+			width-rl.MeasureText(text, textSize)-lineSpacing/2,
+			y,
+			textSize,
+			colorFor(source),
+		)
+		y -= textSize + 4
+	}
 }
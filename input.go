@@ -0,0 +1,152 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//errDisconnected is returned by inputs that notice the device going away
+//by some other means than a failing read
+var errDisconnected = errors.New("device disconnected")
+
+//midiInput is somewhere midi events come from, e.g. a device file or an
+//ALSA sequencer port
+type midiInput interface {
+	//next blocks until an event arrives
+	next() (midiEvent, error)
+	//Write sends raw midi bytes back to the device
+	Write(b []byte) (int, error)
+	Close() error
+
+	//name is a short name to tag events with, e.g. midiC1D0
+	name() string
+	//description is a longer name for status messages
+	description() string
+
+	//reopen blocks until the device is available again after a
+	//disconnect and returns a fresh input for it
+	reopen() midiInput
+}
+
+//isDisconnect reports whether a read error means the device went away
+//(unplugged or switched off) rather than something actually being broken
+func isDisconnect(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ENODEV) ||
+		errors.Is(err, syscall.EIO) ||
+		errors.Is(err, errDisconnected)
+}
+
+//midiSource is one opened input. Events read from it are tagged with it,
+//so they can be shown per device and echoed back to where they came from.
+type midiSource struct {
+	//position in sources, used for labels and colors
+	index int
+
+	mutex     sync.Mutex
+	input     midiInput
+	connected bool
+}
+
+var sources = []*midiSource{}
+
+func addSources(inputs []midiInput) {
+	for _, input := range inputs {
+		sources = append(sources, &midiSource{
+			index:     len(sources),
+			input:     input,
+			connected: true,
+		})
+	}
+}
+
+//name is the short name of the device, e.g. midiC1D0
+func (s *midiSource) name() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.input.name()
+}
+
+func (s *midiSource) description() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.input.description()
+}
+
+func (s *midiSource) isConnected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.connected
+}
+
+//Write sends bytes to the device, or drops them while it is disconnected
+func (s *midiSource) Write(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.connected {
+		return 0, os.ErrClosed
+	}
+
+	return s.input.Write(b)
+}
+
+//listen reads events from the device forever and sends them to events,
+//reopening the device whenever it goes away. The source is sent to changes
+//whenever it disconnects or reconnects.
+func (s *midiSource) listen(events chan<- midiEvent, changes chan<- *midiSource) {
+	input := s.input
+
+	for {
+		ev, err := input.next()
+		if err == nil {
+			ev.source = s
+			ev.time = time.Now()
+			events <- ev
+			continue
+		}
+
+		if !isDisconnect(err) {
+			assertOK(err)
+		}
+
+		s.mutex.Lock()
+		input.Close()
+		s.connected = false
+		s.mutex.Unlock()
+		changes <- s
+
+		input = input.reopen()
+
+		s.mutex.Lock()
+		s.input = input
+		s.connected = true
+		s.mutex.Unlock()
+		changes <- s
+	}
+}
+
+//disconnected resets everything that came from the device, so nothing is
+//left hanging on screen while we wait for it to come back. Settings such as
+//the key signature are left alone.
+func disconnected(source *midiSource) {
+	state.releaseSource(source)
+
+	fmt.Println("# MIDI device", source.description(), "disconnected, waiting for it to come back #")
+}
+
+func reconnected(source *midiSource) {
+	fmt.Println("# Reconnected to MIDI device", source.description(), "#")
+}
@@ -0,0 +1,134 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+)
+
+//interval is the distance between two spelled notes, e.g. a minor third.
+//C to D♯ is an augmented second while C to E♭ is a minor third, even though
+//they sound the same.
+type interval struct {
+	//diatonic steps, 0 for a unison, 7 for an octave
+	steps int
+	//how many semitones bigger than major or perfect, e.g. -1 for minor
+	//(or diminished, for unisons, fourths, fifths and octaves)
+	alter int
+}
+
+//semitones of the major and perfect intervals within an octave, by steps
+var intervalSizes = [7]int{0, 2, 4, 5, 7, 9, 11}
+
+var intervalNumbers = []string{
+	"unison", "second", "third", "fourth", "fifth", "sixth", "seventh",
+	"octave", "ninth", "tenth", "eleventh", "twelfth", "thirteenth",
+	"fourteenth", "fifteenth",
+}
+
+//intervalBetween measures from lower up to upper
+func intervalBetween(lower, upper spelledNote) interval {
+	steps := upper.step() - lower.step()
+	semitones := upper.midi() - lower.midi()
+
+	//B♯3 is written below C♭4 but sounds above it
+	if steps < 0 {
+		return intervalBetween(upper, lower)
+	}
+
+	return interval{
+		steps: steps,
+		alter: semitones - 12*(steps/7) - intervalSizes[steps%7],
+	}
+}
+
+//isPerfect reports whether the interval comes in perfect, diminished and
+//augmented rather than major and minor
+func (i interval) isPerfect() bool {
+	switch i.steps % 7 {
+	case 0, 3, 4:
+		return true
+	}
+
+	return false
+}
+
+func (i interval) quality() (long, short string) {
+	if i.isPerfect() {
+		switch i.alter {
+		case -2:
+			return "doubly diminished", "dd"
+		case -1:
+			return "diminished", "d"
+		case 0:
+			return "perfect", "P"
+		case 1:
+			return "augmented", "A"
+		case 2:
+			return "doubly augmented", "AA"
+		}
+	} else {
+		switch i.alter {
+		case -3:
+			return "doubly diminished", "dd"
+		case -2:
+			return "diminished", "d"
+		case -1:
+			return "minor", "m"
+		case 0:
+			return "major", "M"
+		case 1:
+			return "augmented", "A"
+		case 2:
+			return "doubly augmented", "AA"
+		}
+	}
+
+	return "odd", "?"
+}
+
+//String gives the full name, e.g. "major tenth"
+func (i interval) String() string {
+	quality, _ := i.quality()
+
+	if i.steps < len(intervalNumbers) {
+		return quality + " " + intervalNumbers[i.steps]
+	}
+
+	return fmt.Sprintf("%s %s", quality, ordinal(i.steps+1))
+}
+
+//shortName is the usual abbreviation, e.g. "M10"
+func (i interval) shortName() string {
+	_, quality := i.quality()
+	return fmt.Sprintf("%s%d", quality, i.steps+1)
+}
+
+func ordinal(n int) string {
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+//interval names what is sounding if it is exactly two notes, spelled the
+//way they are drawn
+func (s stateSnapshot) interval() (interval, bool) {
+	if len(s.notes) != 2 {
+		return interval{}, false
+	}
+
+	//notes are sorted from high to low
+	return intervalBetween(spell(s.notes[1].note), spell(s.notes[0].note)), true
+}
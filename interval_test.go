@@ -0,0 +1,82 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestIntervalBetween(t *testing.T) {
+	tests := []struct {
+		lower, upper spelledNote
+		name, short  string
+	}{
+		//simple
+		{spelledNote{'C', 0, 4}, spelledNote{'C', 0, 4}, "perfect unison", "P1"},
+		{spelledNote{'C', 0, 4}, spelledNote{'C', 1, 4}, "augmented unison", "A1"},
+		{spelledNote{'C', 0, 4}, spelledNote{'E', 0, 4}, "major third", "M3"},
+		{spelledNote{'C', 0, 4}, spelledNote{'E', -1, 4}, "minor third", "m3"},
+		{spelledNote{'C', 0, 4}, spelledNote{'D', 1, 4}, "augmented second", "A2"},
+		{spelledNote{'C', 0, 4}, spelledNote{'F', 1, 4}, "augmented fourth", "A4"},
+		{spelledNote{'C', 0, 4}, spelledNote{'G', -1, 4}, "diminished fifth", "d5"},
+		{spelledNote{'C', 1, 4}, spelledNote{'B', -1, 4}, "diminished seventh", "d7"},
+		{spelledNote{'E', 0, 4}, spelledNote{'C', 0, 5}, "minor sixth", "m6"},
+		{spelledNote{'F', 0, 4}, spelledNote{'B', 0, 4}, "augmented fourth", "A4"},
+
+		//compound
+		{spelledNote{'C', 0, 4}, spelledNote{'C', 0, 5}, "perfect octave", "P8"},
+		{spelledNote{'C', 0, 4}, spelledNote{'D', -1, 5}, "minor ninth", "m9"},
+		{spelledNote{'C', 0, 4}, spelledNote{'E', 0, 5}, "major tenth", "M10"},
+		{spelledNote{'A', 0, 3}, spelledNote{'C', 0, 5}, "minor tenth", "m10"},
+		{spelledNote{'C', 0, 3}, spelledNote{'C', 0, 5}, "perfect fifteenth", "P15"},
+		{spelledNote{'C', 0, 3}, spelledNote{'E', 0, 5}, "major 17th", "M17"},
+		{spelledNote{'C', 0, 2}, spelledNote{'D', 0, 5}, "major 23rd", "M23"},
+
+		//the letters wrap around from B to C, whatever the accidentals
+		{spelledNote{'B', 0, 3}, spelledNote{'C', 0, 4}, "minor second", "m2"},
+		{spelledNote{'B', 1, 3}, spelledNote{'C', 0, 4}, "diminished second", "d2"},
+		{spelledNote{'B', 0, 3}, spelledNote{'C', -1, 4}, "diminished second", "d2"},
+		{spelledNote{'B', 1, 3}, spelledNote{'C', -1, 4}, "doubly diminished second", "dd2"},
+		{spelledNote{'C', -1, 4}, spelledNote{'B', 1, 3}, "doubly diminished second", "dd2"},
+		{spelledNote{'C', 0, 4}, spelledNote{'B', 1, 3}, "diminished second", "d2"},
+		{spelledNote{'B', 1, 3}, spelledNote{'C', -1, 5}, "doubly diminished ninth", "dd9"},
+		{spelledNote{'C', -1, 4}, spelledNote{'B', 1, 4}, "doubly augmented seventh", "AA7"},
+	}
+
+	for _, test := range tests {
+		i := intervalBetween(test.lower, test.upper)
+		if got := i.String(); got != test.name {
+			t.Errorf("%v to %v: got %s, want %s", test.lower, test.upper, got, test.name)
+		}
+		if got := i.shortName(); got != test.short {
+			t.Errorf("%v to %v: got %s, want %s", test.lower, test.upper, got, test.short)
+		}
+	}
+}
+
+func TestOrdinal(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{1, "1st"},
+		{2, "2nd"},
+		{3, "3rd"},
+		{4, "4th"},
+		{11, "11th"},
+		{12, "12th"},
+		{13, "13th"},
+		{21, "21st"},
+		{22, "22nd"},
+		{23, "23rd"},
+		{111, "111th"},
+	}
+
+	for _, test := range tests {
+		if got := ordinal(test.n); got != test.want {
+			t.Errorf("%d: got %s, want %s", test.n, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type mode int
+
+const (
+	major mode = iota
+	minor
+	dorian
+	phrygian
+	lydian
+	mixolydian
+	locrian
+)
+
+var modeNames = []string{
+	major:      "major",
+	minor:      "minor",
+	dorian:     "dorian",
+	phrygian:   "phrygian",
+	lydian:     "lydian",
+	mixolydian: "mixolydian",
+	locrian:    "locrian",
+}
+
+//how many fifths the key signature of each mode is away from the major key
+//with the same tonic, e.g. D dorian has two flats less than D major
+var modeFifths = []int{
+	major:      0,
+	minor:      -3,
+	dorian:     -2,
+	phrygian:   -4,
+	lydian:     1,
+	mixolydian: -1,
+	locrian:    -5,
+}
+
+//letters in order of fifths, starting one fifth below C
+const fifthsOrder = "FCGDAEB"
+
+//musicKey is a key as in "B♭ major" or "D dorian"
+type musicKey struct {
+	tonic byte
+	alter int
+	mode  mode
+}
+
+//keySettings is the current key and what setKey derives from it
+type keySettings struct {
+	key musicKey
+	//for the notes outside the key
+	useFlats bool
+	//how many sharps or flats the key signature has, and on which letters
+	signature int
+	sigString string
+}
+
+//the key is changed from the GUI and by the key detector while notes are
+//spelled on other goroutines, so it is only ever read as a copy
+var (
+	keyMutex sync.RWMutex
+	keyNow   = keySettings{key: musicKey{tonic: 'C', mode: major}}
+)
+
+//currentKeySettings returns a copy of the current key and everything
+//derived from it, all from the same moment
+func currentKeySettings() keySettings {
+	keyMutex.RLock()
+	defer keyMutex.RUnlock()
+
+	return keyNow
+}
+
+func currentKey() musicKey {
+	return currentKeySettings().key
+}
+
+//useFlats tells whether notes outside the key are spelled with flats
+func useFlats() bool {
+	return currentKeySettings().useFlats
+}
+
+//fifths is the key signature: how many sharps, or flats if negative
+func (k musicKey) fifths() int {
+	tonicFifths := strings.IndexByte(fifthsOrder, k.tonic) - 1 + 7*k.alter
+	return tonicFifths + modeFifths[k.mode]
+}
+
+//keyFromFifths returns the key in a mode with the given key signature
+func keyFromFifths(fifths int, m mode) musicKey {
+	//+1 because F is one fifth below C
+	tonic := fifths - modeFifths[m] + 1
+
+	letter := ((tonic % 7) + 7) % 7
+	alter := (tonic - letter) / 7
+
+	return musicKey{
+		tonic: fifthsOrder[letter],
+		alter: alter,
+		mode:  m,
+	}
+}
+
+func (k musicKey) tonicName() string {
+	return string(k.tonic) + map[int]string{
+		-2: "𝄫",
+		-1: "♭",
+		0:  "",
+		1:  "♯",
+		2:  "𝄪",
+	}[k.alter]
+}
+
+func (k musicKey) String() string {
+	return k.tonicName() + " " + modeNames[k.mode]
+}
+
+//asciiName is the name without any special symbols, for raylib's default
+//font which doesn't have them
+func (k musicKey) asciiName() string {
+	return string(k.tonic) + map[int]string{
+		-2: "bb",
+		-1: "b",
+		0:  "",
+		1:  "#",
+		2:  "x",
+	}[k.alter] + " " + modeNames[k.mode]
+}
+
+//tonicPitch is the pitch class of the tonic, 0 being C
+func (k musicKey) tonicPitch() int {
+	return ((letterPitches[letterIndex(k.tonic)]+k.alter)%12 + 12) % 12
+}
+
+//degree returns the spelling of a scale degree (1 to 7) of the key, ignoring
+//the octave
+func (k musicKey) degree(n int) spelledNote {
+	letter := letters[(letterIndex(k.tonic)+n-1)%7]
+	return spelledNote{
+		letter: letter,
+		alter:  k.alterOf(letter),
+	}
+}
+
+//alterOf returns how the key signature changes a letter
+func (k musicKey) alterOf(letter byte) int {
+	fifths := k.fifths()
+
+	//sharps are added in fifthsOrder starting at F, flats the other way
+	//round starting at B
+	position := strings.IndexByte(fifthsOrder, letter)
+	switch {
+	case fifths > 0:
+		return (fifths - position + 6) / 7
+	case fifths < 0:
+		return -((-fifths - (6 - position) + 6) / 7)
+	}
+
+	return 0
+}
+
+//hints are notes outside the key signature that still have a fixed
+//spelling, such as the raised leading tone in minor (G♯ in A minor, never
+//A♭)
+func (k musicKey) hints() []spelledNote {
+	raised := func(n int) spelledNote {
+		d := k.degree(n)
+		d.alter++
+		return d
+	}
+
+	switch k.mode {
+	case minor:
+		//harmonic and melodic minor
+		return []spelledNote{raised(7), raised(6)}
+	case dorian, phrygian, mixolydian:
+		return []spelledNote{raised(7)}
+	}
+
+	return nil
+}
+
+//enharmonic returns the same key spelled the other way round, e.g. G♭ major
+//for F♯ major. Keys without one are returned unchanged.
+func (k musicKey) enharmonic() musicKey {
+	fifths := k.fifths()
+
+	switch {
+	case fifths >= 5:
+		return keyFromFifths(fifths-12, k.mode)
+	case fifths <= -5:
+		return keyFromFifths(fifths+12, k.mode)
+	}
+
+	return k
+}
+
+//hasEnharmonic reports whether the key can be spelled the other way round
+func (k musicKey) hasEnharmonic() bool {
+	return k.enharmonic() != k
+}
+
+//parseKey understands names such as "Bb", "F#m", "CM", "c minor", "D dorian"
+//or "Ebmaj". For compatibility a plain number is taken as the number of
+//accidentals in a major key, sharps or flats depending on flats.
+func parseKey(s string, flats bool) (musicKey, error) {
+	s = strings.TrimSpace(s)
+
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 {
+			n = 0
+		}
+		if n > 7 {
+			n = 7
+		}
+		if flats {
+			n = -n
+		}
+		return keyFromFifths(n, major), nil
+	}
+
+	if s == "" {
+		return musicKey{}, errors.New("empty key")
+	}
+
+	k := musicKey{tonic: strings.ToUpper(s[:1])[0]}
+	if letterIndex(k.tonic) < 0 {
+		return k, errors.New("unknown tonic " + s[:1])
+	}
+
+	rest := s[1:]
+	for {
+		switch {
+		case strings.HasPrefix(rest, "#"):
+			k.alter++
+			rest = rest[1:]
+			continue
+		case strings.HasPrefix(rest, "♯"):
+			k.alter++
+			rest = rest[len("♯"):]
+			continue
+		case strings.HasPrefix(rest, "♭"):
+			k.alter--
+			rest = rest[len("♭"):]
+			continue
+		case strings.HasPrefix(rest, "b"):
+			k.alter--
+			rest = rest[1:]
+			continue
+		}
+		break
+	}
+
+	//M is major and m is minor, like in chord symbols
+	if strings.TrimSpace(rest) == "M" {
+		rest = "maj"
+	}
+
+	switch strings.ToLower(strings.TrimSpace(rest)) {
+	case "", "maj", "major", "ionian":
+		k.mode = major
+	case "m", "min", "minor", "aeolian":
+		k.mode = minor
+	default:
+		found := false
+		for m, name := range modeNames {
+			if strings.EqualFold(strings.TrimSpace(rest), name) {
+				k.mode = mode(m)
+				found = true
+			}
+		}
+		if !found {
+			return k, errors.New("unknown mode " + strings.TrimSpace(rest))
+		}
+	}
+
+	if f := k.fifths(); f > 7 || f < -7 {
+		return k, errors.New("key signature of " + k.String() + " would need more than 7 accidentals")
+	}
+
+	return k, nil
+}
+
+//setKey makes k the current key and updates everything derived from it.
+//Keys without accidentals keep whichever of sharps and flats was used
+//before, for the notes that aren't in the key.
+func setKey(k musicKey) {
+	keyMutex.Lock()
+	defer keyMutex.Unlock()
+
+	keyNow.key = k
+
+	fifths := k.fifths()
+	switch {
+	case fifths > 0:
+		keyNow.useFlats = false
+		keyNow.signature = fifths
+	case fifths < 0:
+		keyNow.useFlats = true
+		keyNow.signature = -fifths
+	default:
+		keyNow.signature = 0
+	}
+
+	keyNow.sigString = map[bool]string{
+		false: sharpKeySignatures,
+		true:  flatKeySignatures,
+	}[keyNow.useFlats][:keyNow.signature]
+}
+
+//setUseFlats changes how notes outside the key are spelled. Only keys
+//without accidentals keep it, others go by their key signature.
+func setUseFlats(flats bool) {
+	keyMutex.Lock()
+	defer keyMutex.Unlock()
+
+	keyNow.useFlats = flats
+}
@@ -0,0 +1,98 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+//run with -race: the GUI and the key detector change the key while notes
+//are spelled for the terminal
+func TestKeyConcurrentAccess(t *testing.T) {
+	before := currentKeySettings()
+	defer func() {
+		setUseFlats(before.useFlats)
+		setKey(before.key)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for fifths := -7; fifths <= 7; fifths++ {
+			setKey(keyFromFifths(fifths, major))
+			setUseFlats(fifths%2 == 0)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		for note := byte(60); note < 72; note++ {
+			if n := spell(note); n.midi() != int(note) {
+				t.Fatalf("%d spelled as %v", note, n)
+			}
+		}
+	}
+	wg.Wait()
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"C", "C major"},
+		{"CM", "C major"},
+		{"Cm", "C minor"},
+		{"cm", "C minor"},
+		{"F#m", "F♯ minor"},
+		{"Ebmaj", "E♭ major"},
+		{"BbM", "B♭ major"},
+		{"c minor", "C minor"},
+		{"D dorian", "D dorian"},
+		{"3", "A major"},
+	}
+
+	for _, test := range tests {
+		k, err := parseKey(test.s, false)
+		if err != nil {
+			t.Errorf("%q: %v", test.s, err)
+			continue
+		}
+		if got := k.String(); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.s, got, test.want)
+		}
+	}
+
+	for _, s := range []string{"", "H", "CMM", "C mixed", "G#"} {
+		if k, err := parseKey(s, false); err == nil {
+			t.Errorf("%q: got %s, want an error", s, k)
+		}
+	}
+}
+
+func TestHasEnharmonic(t *testing.T) {
+	tests := []struct {
+		fifths int
+		want   bool
+	}{
+		{0, false},
+		{1, false},
+		{4, false},
+		{-4, false},
+		{5, true},
+		{6, true},
+		{7, true},
+		{-5, true},
+		{-7, true},
+	}
+
+	for _, test := range tests {
+		k := keyFromFifths(test.fifths, major)
+		if got := k.hasEnharmonic(); got != test.want {
+			t.Errorf("%s: got %v, want %v", k, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	//how far back notes are taken into account
+	keyWindow = 20 * time.Second
+	//an estimate has to stay the same this long before it is used
+	keyStableTime = 4 * time.Second
+	//and be at least this sure
+	keyMinConfidence = 0.6
+	//notes held longer than this don't count any more than this
+	keyMaxNoteWeight = 4 * time.Second
+	//no point in evaluating every single frame
+	keyUpdateInterval = 250 * time.Millisecond
+)
+
+//Krumhansl-Kessler key profiles, starting at the tonic
+var (
+	majorProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+	minorProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+)
+
+type detectedNote struct {
+	pitchClass int
+	velocity   byte
+	onset      time.Time
+	//zero while the key is still held
+	release time.Time
+}
+
+//keyDetector guesses the key from what was played recently. Notes come in
+//from the midi goroutine, while update is called by whoever owns the key
+//(the GUI loop, or the midi goroutine without GUI).
+type keyDetector struct {
+	mutex sync.Mutex
+	notes []detectedNote
+
+	//whether the estimate should change the key
+	enabled bool
+	//keep the current key no matter what
+	locked bool
+
+	estimate   musicKey
+	confidence float64
+	hasGuess   bool
+
+	//how long the estimate has been the same
+	since      time.Time
+	lastUpdate time.Time
+}
+
+var detector = &keyDetector{}
+
+func (d *keyDetector) noteOn(ev midiEvent) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.notes = append(d.notes, detectedNote{
+		pitchClass: int(ev.data[0]) % 12,
+		velocity:   ev.data[1],
+		onset:      eventTime(ev),
+	})
+}
+
+func (d *keyDetector) noteOff(ev midiEvent) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	pitchClass := int(ev.data[0]) % 12
+	for i := len(d.notes) - 1; i >= 0; i-- {
+		if d.notes[i].pitchClass == pitchClass && d.notes[i].release.IsZero() {
+			d.notes[i].release = eventTime(ev)
+			return
+		}
+	}
+}
+
+//histogram weighs every pitch class by how long and how loud it was played
+func (d *keyDetector) histogram(now time.Time) (hist [12]float64, total float64) {
+	kept := d.notes[:0]
+
+	for _, n := range d.notes {
+		end := n.release
+		if end.IsZero() {
+			end = now
+		}
+		if now.Sub(end) > keyWindow {
+			continue
+		}
+		kept = append(kept, n)
+
+		held := end.Sub(n.onset)
+		if held > keyMaxNoteWeight {
+			held = keyMaxNoteWeight
+		}
+
+		weight := held.Seconds() * float64(n.velocity) / 127
+		hist[n.pitchClass] += weight
+		total += weight
+	}
+	d.notes = kept
+
+	return hist, total
+}
+
+//correlation is Pearson's r between the histogram and a profile rotated to
+//start at tonic
+func correlation(hist [12]float64, profile [12]float64, tonic int) float64 {
+	meanHist, meanProfile := 0.0, 0.0
+	for i := 0; i < 12; i++ {
+		meanHist += hist[i] / 12
+		meanProfile += profile[i] / 12
+	}
+
+	covariance, varHist, varProfile := 0.0, 0.0, 0.0
+	for i := 0; i < 12; i++ {
+		h := hist[(i+tonic)%12] - meanHist
+		p := profile[i] - meanProfile
+
+		covariance += h * p
+		varHist += h * h
+		varProfile += p * p
+	}
+
+	if varHist == 0 {
+		return 0
+	}
+
+	return covariance / math.Sqrt(varHist*varProfile)
+}
+
+//keyForPitch returns the key with the given tonic pitch class and mode,
+//spelled with as few accidentals as possible
+func keyForPitch(pitchClass int, m mode) musicKey {
+	best := musicKey{}
+	bestFifths := 100
+
+	for fifths := -7; fifths <= 7; fifths++ {
+		k := keyFromFifths(fifths, m)
+		if k.tonicPitch() != pitchClass {
+			continue
+		}
+
+		//F♯ or G♭: go with what is being used already
+		better := abs(fifths) < abs(bestFifths) ||
+			(abs(fifths) == abs(bestFifths) && (fifths < 0) == useFlats())
+		if better {
+			best = k
+			bestFifths = fifths
+		}
+	}
+
+	return best
+}
+
+//update refreshes the estimate and changes the key if it has been stable
+//for long enough. Must only be called from the goroutine that owns the key.
+func (d *keyDetector) update() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(d.lastUpdate) < keyUpdateInterval {
+		return
+	}
+	d.lastUpdate = now
+
+	hist, total := d.histogram(now)
+	if total == 0 {
+		d.hasGuess = false
+		return
+	}
+
+	best, bestR := musicKey{}, math.Inf(-1)
+	for tonic := 0; tonic < 12; tonic++ {
+		if r := correlation(hist, majorProfile, tonic); r > bestR {
+			best, bestR = keyForPitch(tonic, major), r
+		}
+		if r := correlation(hist, minorProfile, tonic); r > bestR {
+			best, bestR = keyForPitch(tonic, minor), r
+		}
+	}
+
+	if !d.hasGuess || best != d.estimate {
+		d.since = now
+	}
+	d.estimate = best
+	d.confidence = bestR
+	d.hasGuess = true
+
+	stable := now.Sub(d.since) >= keyStableTime && bestR >= keyMinConfidence
+	if d.enabled && !d.locked && stable && best != currentKey() {
+		setKey(best)
+		fmt.Printf("# Detected key: %s (%.0f%% sure) #\n", best, 100*bestR)
+	}
+}
+
+//guess returns the current estimate, ok is false if there isn't one yet
+func (d *keyDetector) guess() (k musicKey, confidence float64, ok bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.estimate, d.confidence, d.hasGuess
+}
+
+func (d *keyDetector) isEnabled() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.enabled
+}
+
+func (d *keyDetector) isLocked() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.locked
+}
+
+func (d *keyDetector) setEnabled(enabled bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.enabled = enabled
+}
+
+func (d *keyDetector) setLocked(locked bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.locked = locked
+}
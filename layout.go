@@ -0,0 +1,315 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+//headLayout is where everything belonging to one held note goes, worked out
+//before anything is drawn. Positions are in pixels, glyphs are placed by
+//their top left corner like the renderer does.
+type headLayout struct {
+	note  byte
+	glyph string
+	color rl.Color
+	x, y  float32
+	//on the other side of the stem than the rest of the chord
+	displaced bool
+
+	//ledger lines, each as wide as a note head plus a bit on both sides.
+	//Those of displaced heads overlap the others, which widens them.
+	ledgerX  float32
+	ledgerYs []float32
+
+	//empty if the key signature already says what to do with the note
+	accidental      string
+	accidentalX     float32
+	accidentalColor rl.Color
+}
+
+//stemLayout is a stem shared by all notes of a chord on one staff
+type stemLayout struct {
+	x           float32
+	top, bottom float32
+}
+
+//chordLayout is the chord being held, laid out on both staves
+type chordLayout struct {
+	heads []headLayout
+	stems []stemLayout
+}
+
+//layoutHeldNotes lays out the chord being held at noteX. notes have to be
+//sorted like in stateSnapshot. Middle C and up go on the treble staff, the
+//same as on the timeline, and each staff gets its own stem.
+func layoutHeldNotes(notes []heldNote) chordLayout {
+	treble, bass := []heldNote{}, []heldNote{}
+	for _, held := range notes {
+		if held.note >= 60 {
+			treble = append(treble, held)
+		} else {
+			bass = append(bass, held)
+		}
+	}
+
+	ret := chordLayout{}
+	for _, staff := range []struct {
+		notes   []heldNote
+		middleY int32
+	}{
+		{treble, trebleMiddleLineY},
+		{bass, bassMiddleLineY},
+	} {
+		if len(staff.notes) == 0 {
+			continue
+		}
+
+		ys := make([]int32, len(staff.notes))
+		for i, held := range staff.notes {
+			ys[i] = yOffsetFor(held.note) + 2*lineSpacing
+		}
+		highestY, lowestY := ys[0], ys[len(ys)-1]
+
+		//the stem goes on the side with more room, away from whichever end
+		//is further from the middle line
+		stemDown := staff.middleY-highestY > lowestY-staff.middleY
+		displaced := displacedHeads(ys, stemDown)
+		noteNumbers := make([]byte, len(staff.notes))
+		for i, held := range staff.notes {
+			noteNumbers[i] = held.note
+		}
+		accidentalXs := placeAccidentals(noteNumbers, leftmostHeadX(noteX, displaced, stemDown))
+
+		for i, held := range staff.notes {
+			yOff := yOffsetFor(held.note)
+			glyph, color := noteHeadStyle(held)
+			accidental, _ := accidentalFor(held.note)
+			x := headXFor(noteX, displaced[i], stemDown)
+
+			ret.heads = append(ret.heads, headLayout{
+				note:      held.note,
+				glyph:     glyph,
+				color:     color,
+				x:         x,
+				y:         float32(yOff),
+				displaced: displaced[i],
+
+				ledgerX:  x,
+				ledgerYs: ledgerLinesFor(yOff, ys[i]),
+
+				accidental:      accidental,
+				accidentalX:     accidentalXs[i],
+				accidentalColor: colorFor(held.source),
+			})
+		}
+
+		stem := stemLayout{
+			x:      stemXFor(noteX, stemDown),
+			top:    float32(naturalStemEnd(highestY, false, staff.middleY)),
+			bottom: float32(lowestY),
+		}
+		if stemDown {
+			stem.top = float32(highestY)
+			stem.bottom = float32(naturalStemEnd(lowestY, true, staff.middleY))
+		}
+		ret.stems = append(ret.stems, stem)
+	}
+
+	return ret
+}
+
+//displacedHeads decides which note heads of a chord go on the other side of
+//the stem. ys are the notes' positions from top to bottom. Starting at the
+//note furthest from the end of the stem, a note a second (or less) away from
+//one that is on the normal side is moved over, so seconds always sit side
+//by side and clusters zigzag.
+func displacedHeads(ys []int32, stemDown bool) []bool {
+	ret := make([]bool, len(ys))
+
+	order := make([]int, len(ys))
+	for i := range order {
+		//bottom up for stems going up, top down for stems going down
+		order[i] = len(ys) - 1 - i
+		if stemDown {
+			order[i] = i
+		}
+	}
+
+	for n := 1; n < len(order); n++ {
+		i, previous := order[n], order[n-1]
+		distance := ys[i] - ys[previous]
+		if distance < 0 {
+			distance = -distance
+		}
+
+		ret[i] = distance <= lineSpacing/2 && !ret[previous]
+	}
+
+	return ret
+}
+
+//headXFor is where a note head of a chord at x goes: displaced heads are to
+//the right of an up stem, or to the left of a down stem
+func headXFor(x float32, displaced, stemDown bool) float32 {
+	switch {
+	case displaced && stemDown:
+		return x - (noteWidth - lineThickness)
+	case displaced:
+		return x + noteWidth - lineThickness
+	}
+
+	return x
+}
+
+//leftmostHeadX is where the leftmost note head of a chord at x is, which
+//its accidentals have to stay clear of
+func leftmostHeadX(x float32, displaced []bool, stemDown bool) float32 {
+	for _, d := range displaced {
+		if d && stemDown {
+			return headXFor(x, true, true)
+		}
+	}
+
+	return x
+}
+
+//ledgerLinesFor returns where the ledger lines of a note go, if it needs any
+func ledgerLinesFor(yOff, apparentNoteY int32) []float32 {
+	ret := []float32{}
+
+	ledgersNeededAbove := apparentNoteY <= trebleMiddleLineY-3*lineSpacing
+	if ledgersNeededAbove {
+		for y := float32(trebleMiddleLineY - 3*lineSpacing); y >= float32(apparentNoteY); y -= lineSpacing {
+			ret = append(ret, y)
+		}
+	}
+
+	if yOff == middleCY {
+		ret = append(ret, float32(halfHeight))
+	}
+
+	ledgersNeededBelow := apparentNoteY >= bassMiddleLineY+3*lineSpacing
+	if ledgersNeededBelow {
+		for y := float32(bassMiddleLineY + 3*lineSpacing); y <= float32(apparentNoteY); y += lineSpacing {
+			ret = append(ret, y)
+		}
+	}
+
+	return ret
+}
+
+//accidentalBox is how far an accidental reaches above and below the note it
+//belongs to, and how wide it is, in staff spaces (from Bravura's bounding
+//boxes)
+type accidentalBox struct {
+	above, below, width float32
+}
+
+var accidentalBoxes = map[int]accidentalBox{
+	-2: {1.748, 0.7, 1.644},
+	-1: {1.756, 0.7, 0.904},
+	0:  {1.364, 1.34, 0.672},
+	1:  {1.4, 1.392, 0.996},
+	2:  {0.508, 0.5, 0.988},
+}
+
+const (
+	//between the accidentals and the note heads
+	accidentalGap = lineSpacing / 2
+	//between two columns of accidentals
+	accidentalColumnGap = lineSpacing / 5
+)
+
+//placeAccidentals returns where the accidentals of a chord go (and 0 for
+//notes without one), right of rightX. notes have to be sorted from highest
+//to lowest.
+//
+//The accidentals are taken in zigzag order, outermost first: the highest,
+//the lowest, the second highest and so on. Each goes into the first column
+//(counting from the notes) where it doesn't run into the accidentals already
+//there, and every column is as wide as its widest accidental.
+func placeAccidentals(notes []byte, rightX float32) []float32 {
+	type placed struct {
+		index       int
+		top, bottom float32
+		width       float32
+	}
+
+	alters := make([]int, len(notes))
+	needed := []int{}
+	for i, note := range notes {
+		if _, ok := accidentalFor(note); ok {
+			alters[i] = spell(note).alter
+			needed = append(needed, i)
+		}
+	}
+
+	order := []int{}
+	for low, high := 0, len(needed)-1; low <= high; low, high = low+1, high-1 {
+		order = append(order, needed[low])
+		if high != low {
+			order = append(order, needed[high])
+		}
+	}
+
+	columns := [][]placed{}
+	for _, i := range order {
+		box := accidentalBoxes[alters[i]]
+		y := float32(yOffsetFor(notes[i]) + 2*lineSpacing)
+		p := placed{
+			index:  i,
+			top:    y - box.above*lineSpacing,
+			bottom: y + box.below*lineSpacing,
+			width:  box.width * lineSpacing,
+		}
+
+		column := 0
+		for ; column < len(columns); column++ {
+			collides := false
+			for _, other := range columns[column] {
+				if p.top < other.bottom && other.top < p.bottom {
+					collides = true
+					break
+				}
+			}
+			if !collides {
+				break
+			}
+		}
+
+		if column == len(columns) {
+			columns = append(columns, []placed{})
+		}
+		columns[column] = append(columns[column], p)
+	}
+
+	ret := make([]float32, len(notes))
+	right := rightX - accidentalGap
+	for _, column := range columns {
+		widest := float32(0)
+		for _, p := range column {
+			//lined up on their right edges
+			ret[p.index] = right - p.width
+			if p.width > widest {
+				widest = p.width
+			}
+		}
+		right -= widest + accidentalColumnGap
+	}
+
+	return ret
+}
+
+//accidentalFor returns the accidental a note needs, unless the key
+//signature already says what to do with it
+func accidentalFor(note byte) (string, bool) {
+	spelled := spell(note)
+	if spelled.alter == keyAlter(spelled.letter) {
+		return "", false
+	}
+
+	return accidentalGlyph(spelled.alter), true
+}
@@ -0,0 +1,286 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+//withNoteWidth measures note heads like a snapshot does, since there is no
+//font loaded in tests, returning how to put it back
+func withNoteWidth() func() {
+	previous := noteWidth
+	noteWidth = (&svgRenderer{}).glyphWidth("")
+
+	return func() {
+		noteWidth = previous
+	}
+}
+
+//heldNotes holds notes down, sorted from highest to lowest like in a
+//stateSnapshot
+func heldNotes(notes ...byte) []heldNote {
+	ret := []heldNote{}
+	for _, n := range notes {
+		ret = append(ret, heldNote{note: n, velocity: 64})
+	}
+
+	return ret
+}
+
+//apparentY is where a note's head is centred
+func apparentY(note byte) int32 {
+	return yOffsetFor(note) + 2*lineSpacing
+}
+
+func TestDisplacedHeads(t *testing.T) {
+	tests := []struct {
+		name     string
+		ys       []int32
+		stemDown bool
+		want     []bool
+	}{
+		{"single note", []int32{0}, false, []bool{false}},
+		{"third", []int32{0, 32}, false, []bool{false, false}},
+		{"second with the stem up", []int32{0, 16}, false, []bool{true, false}},
+		{"second with the stem down", []int32{0, 16}, true, []bool{false, true}},
+		{"three note cluster", []int32{0, 16, 32}, false, []bool{false, true, false}},
+		{"four note cluster", []int32{0, 16, 32, 48}, false, []bool{true, false, true, false}},
+		{"second over a third", []int32{0, 16, 48}, false, []bool{true, false, false}},
+		{"third over a second", []int32{0, 32, 48}, false, []bool{false, true, false}},
+		{"three note cluster with the stem down", []int32{0, 16, 32}, true, []bool{false, true, false}},
+		{"four note cluster with the stem down", []int32{0, 16, 32, 48}, true, []bool{false, true, false, true}},
+		{"second over a third with the stem down", []int32{0, 16, 48}, true, []bool{false, true, false}},
+		{"third over a second with the stem down", []int32{0, 32, 48}, true, []bool{false, false, true}},
+		{"two seconds a third apart", []int32{0, 16, 48, 64}, false, []bool{true, false, true, false}},
+	}
+
+	for _, test := range tests {
+		got := displacedHeads(test.ys, test.stemDown)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHeadXFor(t *testing.T) {
+	defer withNoteWidth()()
+
+	offset := noteWidth - lineThickness
+	tests := []struct {
+		displaced, stemDown bool
+		want                float32
+	}{
+		{false, false, 100},
+		{false, true, 100},
+		{true, false, 100 + offset},
+		{true, true, 100 - offset},
+	}
+
+	for _, test := range tests {
+		if got := headXFor(100, test.displaced, test.stemDown); got != test.want {
+			t.Errorf("displaced %v, stem down %v: got %v, want %v", test.displaced, test.stemDown, got, test.want)
+		}
+	}
+
+	if got := leftmostHeadX(100, []bool{false, true}, true); got != 100-offset {
+		t.Errorf("leftmost head with the stem down: got %v, want %v", got, 100-offset)
+	}
+	if got := leftmostHeadX(100, []bool{true, false}, false); got != 100 {
+		t.Errorf("leftmost head with the stem up: got %v, want %v", got, 100)
+	}
+}
+
+func TestLedgerLinesFor(t *testing.T) {
+	defer inKeyOf(t, "C")()
+
+	above := func(n int) []float32 {
+		ret := []float32{}
+		for i := 0; i < n; i++ {
+			ret = append(ret, float32(trebleMiddleLineY-(3+i)*lineSpacing))
+		}
+		return ret
+	}
+	below := func(n int) []float32 {
+		ret := []float32{}
+		for i := 0; i < n; i++ {
+			ret = append(ret, float32(bassMiddleLineY+(3+i)*lineSpacing))
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name string
+		note byte
+		want []float32
+	}{
+		{"middle C", 60, []float32{halfHeight}},
+		{"on the treble staff", 71, above(0)},
+		{"top line of the treble staff", 77, above(0)},
+		{"above the treble staff", 79, above(0)},
+		{"first ledger line above", 81, above(1)},
+		{"in the space above it", 83, above(1)},
+		{"highest note of a piano", 108, above(9)},
+		{"on the bass staff", 50, below(0)},
+		{"below the bass staff", 41, below(0)},
+		{"first ledger line below", 40, below(1)},
+		{"lowest note of a piano", 21, below(6)},
+	}
+
+	for _, test := range tests {
+		got := ledgerLinesFor(yOffsetFor(test.note), apparentY(test.note))
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s (%d): got %v, want %v", test.name, test.note, got, test.want)
+		}
+	}
+}
+
+func TestPlaceAccidentals(t *testing.T) {
+	//one sharp right of its note, with a gap in between
+	sharpX := 500 - accidentalGap - accidentalBoxes[1].width*lineSpacing
+
+	tests := []struct {
+		name  string
+		key   string
+		notes []byte
+		want  []float32
+	}{
+		{"no accidentals", "C", []byte{67, 64, 60}, []float32{0, 0, 0}},
+		{"one sharp", "C", []byte{66}, []float32{sharpX}},
+		{"sharp in the key signature", "G", []byte{66}, []float32{0}},
+		{"natural against the key signature", "G", []byte{65}, []float32{500 - accidentalGap - accidentalBoxes[0].width*lineSpacing}},
+		{"key signature and not", "D", []byte{73, 66, 62}, []float32{0, 0, 0}},
+		{"octave apart", "C", []byte{78, 66}, []float32{sharpX, sharpX}},
+	}
+
+	for _, test := range tests {
+		restore := inKeyOf(t, test.key)
+		got := placeAccidentals(test.notes, 500)
+		restore()
+
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPlaceAccidentalsColumns(t *testing.T) {
+	sharp := accidentalBoxes[1].width * lineSpacing
+	natural := accidentalBoxes[0].width * lineSpacing
+	column := float32(500 - accidentalGap)
+	nextColumn := func(widest float32) float32 {
+		return column - widest - accidentalColumnGap
+	}
+	//where a sharp goes in the nth column of nothing but sharps
+	sharps := func(n int) float32 {
+		right := column
+		for i := 0; i < n; i++ {
+			right -= sharp + accidentalColumnGap
+		}
+		return right - sharp
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		notes []byte
+		want  []float32
+	}{
+		{"a sixth apart", "C", []byte{75, 66}, []float32{column - sharp, nextColumn(sharp) - sharp}},
+		{"a seventh apart", "C", []byte{73, 63}, []float32{column - sharp, column - sharp}},
+		{"outer ones first", "C", []byte{85, 75, 66}, []float32{column - sharp, nextColumn(sharp) - sharp, column - sharp}},
+		{"a natural above a sharp", "G", []byte{77, 73}, []float32{column - natural, nextColumn(natural) - sharp}},
+		{"columns as wide as their widest", "G", []byte{85, 73, 65}, []float32{column - sharp, nextColumn(sharp) - sharp, column - natural}},
+		{"four in a cluster", "C", []byte{75, 73, 70, 68}, []float32{sharps(0), sharps(2), sharps(3), sharps(1)}},
+	}
+
+	for _, test := range tests {
+		restore := inKeyOf(t, test.key)
+		got := placeAccidentals(test.notes, 500)
+		restore()
+
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLayoutHeldNotes(t *testing.T) {
+	defer inKeyOf(t, "C")()
+	defer withNoteWidth()()
+
+	right := headXFor(noteX, true, false)
+	left := headXFor(noteX, true, true)
+
+	tests := []struct {
+		name  string
+		notes []heldNote
+		//x of every note head, highest first
+		xs    []float32
+		stems int
+	}{
+		{"nothing", heldNotes(), []float32{}, 0},
+		{"triad", heldNotes(67, 64, 60), []float32{noteX, noteX, noteX}, 1},
+		{"second on the treble staff", heldNotes(65, 64), []float32{right, noteX}, 1},
+		{"second on the bass staff", heldNotes(45, 43), []float32{right, noteX}, 1},
+		{"seconds on both staves", heldNotes(65, 64, 45, 43), []float32{right, noteX, right, noteX}, 2},
+		{"cluster around middle C", heldNotes(65, 64, 62, 60), []float32{right, noteX, right, noteX}, 1},
+		{"one note on each staff", heldNotes(72, 48), []float32{noteX, noteX}, 2},
+		{"second with the stem down", heldNotes(79, 77), []float32{noteX, left}, 1},
+		{"cluster with the stem down", heldNotes(81, 79, 77, 76), []float32{noteX, left, noteX, left}, 1},
+		{"clusters on both sides of the stem", heldNotes(81, 79, 77, 45, 43, 41), []float32{noteX, left, noteX, noteX, right, noteX}, 2},
+	}
+
+	for _, test := range tests {
+		layout := layoutHeldNotes(test.notes)
+
+		xs := []float32{}
+		for i, head := range layout.heads {
+			xs = append(xs, head.x)
+			if head.note != test.notes[i].note {
+				t.Errorf("%s: head %d is %d, want %d", test.name, i, head.note, test.notes[i].note)
+			}
+		}
+		if fmt.Sprint(xs) != fmt.Sprint(test.xs) {
+			t.Errorf("%s: heads at %v, want %v", test.name, xs, test.xs)
+		}
+		if len(layout.stems) != test.stems {
+			t.Errorf("%s: %d stems, want %d", test.name, len(layout.stems), test.stems)
+		}
+	}
+}
+
+func TestLayoutHeldNotesStems(t *testing.T) {
+	defer inKeyOf(t, "C")()
+	defer withNoteWidth()()
+
+	//down on the treble staff, up on the bass staff
+	layout := layoutHeldNotes(heldNotes(81, 79, 77, 45, 43, 41))
+	if len(layout.stems) != 2 {
+		t.Fatalf("%d stems, want 2", len(layout.stems))
+	}
+
+	down, up := layout.stems[0], layout.stems[1]
+	if down.x != stemXFor(noteX, true) || down.top != float32(apparentY(81)) {
+		t.Errorf("treble stem: got %+v", down)
+	}
+	if up.x != stemXFor(noteX, false) || up.bottom != float32(apparentY(41)) {
+		t.Errorf("bass stem: got %+v", up)
+	}
+}
+
+func TestLayoutHeldNotesLedgerLines(t *testing.T) {
+	defer inKeyOf(t, "C")()
+	defer withNoteWidth()()
+
+	layout := layoutHeldNotes(heldNotes(108, 60, 21))
+	want := []int{9, 1, 6}
+	for i, head := range layout.heads {
+		if len(head.ledgerYs) != want[i] {
+			t.Errorf("%d: %d ledger lines, want %d", head.note, len(head.ledgerYs), want[i])
+		}
+	}
+}
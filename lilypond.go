@@ -0,0 +1,242 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//dynamics from softest to loudest, with the highest velocity that still
+//counts as each
+var lilyPondDynamics = []struct {
+	mark        string
+	maxVelocity byte
+}{
+	{`\ppp`, 23},
+	{`\pp`, 39},
+	{`\p`, 55},
+	{`\mp`, 71},
+	{`\mf`, 87},
+	{`\f`, 103},
+	{`\ff`, 119},
+	{`\fff`, 127},
+}
+
+//writeLilyPond writes the recording as a LilyPond piano score, with the
+//pedals in a Dynamics context between the staves
+func writeLilyPond(w io.Writer, r *recorder) error {
+	s := r.session()
+	treble, bass := s.staves()
+
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, `\version "2.22.0"`)
+	fmt.Fprintln(out)
+	writeLilyPondStaff(out, "upper", "treble", s.measures(treble, s.measureCount()))
+	writeLilyPondStaff(out, "lower", "bass", s.measures(bass, s.measureCount()))
+	writeLilyPondPedals(out, s)
+
+	fmt.Fprintln(out, `\score {`)
+	fmt.Fprintln(out, `  \new PianoStaff <<`)
+	fmt.Fprintln(out, `    \new Staff = "upper" \upper`)
+	fmt.Fprintln(out, `    \new Dynamics \pedals`)
+	fmt.Fprintln(out, `    \new Staff = "lower" \lower`)
+	fmt.Fprintln(out, `  >>`)
+	fmt.Fprintln(out, `  \layout { }`)
+	fmt.Fprintln(out, `}`)
+
+	return out.Flush()
+}
+
+//lilyPondName is a note name in LilyPond's default (Dutch) names, e.g.
+//"fis" or "bes", without the octave
+func lilyPondName(letter byte, alter int) string {
+	name := strings.ToLower(string(letter))
+	if alter > 0 {
+		name += strings.Repeat("is", alter)
+	}
+	if alter < 0 {
+		name += strings.Repeat("es", -alter)
+	}
+
+	//"ees" and "aes" are spelled "es" and "as"
+	name = strings.Replace(name, "ees", "es", 1)
+	name = strings.Replace(name, "aes", "as", 1)
+
+	return name
+}
+
+//lilyPondPitch writes a note in absolute pitch, where c is the C below
+//middle C
+func lilyPondPitch(n spelledNote) string {
+	octave := ""
+	if n.octave > 3 {
+		octave = strings.Repeat("'", n.octave-3)
+	}
+	if n.octave < 3 {
+		octave = strings.Repeat(",", 3-n.octave)
+	}
+
+	return lilyPondName(n.letter, n.alter) + octave
+}
+
+func lilyPondDuration(v noteValue) string {
+	return fmt.Sprint(v.base) + strings.Repeat(".", v.dots)
+}
+
+func lilyPondDynamic(velocity byte) string {
+	for _, d := range lilyPondDynamics {
+		if velocity <= d.maxVelocity {
+			return d.mark
+		}
+	}
+
+	return ""
+}
+
+//writeLilyPondStaff writes one staff as a variable, a measure per line.
+//Triplets that follow each other share a \tuplet, and a dynamic is added
+//whenever the velocity changes enough to need a different one.
+func writeLilyPondStaff(out io.Writer, name, clef string, measures [][]notatedChord) {
+	fmt.Fprintf(out, "%s = {\n", name)
+	fmt.Fprintf(out, "  \\clef %s\n", clef)
+	fmt.Fprintf(out, "  \\key %s \\%s\n",
+		lilyPondName(currentKey().tonic, currentKey().alter),
+		modeNames[currentKey().mode],
+	)
+	fmt.Fprintf(out, "  \\time %s\n", timeSig)
+
+	dynamic := ""
+	for _, measure := range measures {
+		parts := []string{}
+		inTuplet := false
+
+		for _, c := range measure {
+			if c.value.triplet && !inTuplet {
+				parts = append(parts, `\tuplet 3/2 {`)
+				inTuplet = true
+			}
+			if !c.value.triplet && inTuplet {
+				parts = append(parts, "}")
+				inTuplet = false
+			}
+
+			if c.isRest() {
+				parts = append(parts, "r"+lilyPondDuration(c.value))
+				continue
+			}
+
+			pitches := []string{}
+			loudest := byte(0)
+			for i, p := range c.notes {
+				pitch := lilyPondPitch(spell(p.note))
+				//in a chord each note is tied on its own
+				if c.tiedToNext[i] && len(c.notes) > 1 {
+					pitch += "~"
+				}
+				pitches = append(pitches, pitch)
+
+				if p.velocity > loudest && !c.tiedFromPrevious[i] {
+					loudest = p.velocity
+				}
+			}
+
+			part := pitches[0]
+			if len(pitches) > 1 {
+				part = "<" + strings.Join(pitches, " ") + ">"
+			}
+			part += lilyPondDuration(c.value)
+
+			//held on notes don't get played again
+			if d := lilyPondDynamic(loudest); d != dynamic && c.struck() {
+				part += d
+				dynamic = d
+			}
+			if len(c.notes) == 1 && c.tiedToNext[0] {
+				part += "~"
+			}
+
+			parts = append(parts, part)
+		}
+
+		if inTuplet {
+			parts = append(parts, "}")
+		}
+
+		fmt.Fprintf(out, "  %s |\n", strings.Join(parts, " "))
+	}
+
+	fmt.Fprintln(out, "}")
+	fmt.Fprintln(out)
+}
+
+//writeLilyPondPedals writes the pedal changes as spacer rests with pedal
+//marks, lasting as long as the music
+func writeLilyPondPedals(out io.Writer, s session) {
+	fmt.Fprintln(out, "pedals = {")
+
+	marks := map[byte][2]string{
+		SUSTAIN:   {`\sustainOff`, `\sustainOn`},
+		SOSTENUTO: {`\sostenutoOff`, `\sostenutoOn`},
+	}
+
+	end := s.measureCount() * measureTicks()
+	position := 0
+	pending := ""
+
+	//a spacer rest of any length, as a fraction of a whole note
+	spacer := func(ticks int) {
+		if ticks <= 0 {
+			return
+		}
+
+		whole := 4 * ticksPerQuarter
+		divisor := gcd(ticks, whole)
+		fmt.Fprintf(out, "  s1*%d/%d%s\n", ticks/divisor, whole/divisor, pending)
+		pending = ""
+	}
+
+	for _, change := range s.pedals {
+		at := quantize(s.start, change.at)
+		if at < 0 {
+			at = 0
+		}
+		if at > end {
+			at = end
+		}
+
+		if at > position {
+			spacer(at - position)
+			position = at
+		}
+
+		down := 0
+		if change.down {
+			down = 1
+		}
+		pending += marks[change.controller][down]
+	}
+
+	if position < end {
+		spacer(end - position)
+	} else if pending != "" {
+		//the pedal went up right at the end
+		fmt.Fprintf(out, "  s1*0%s\n", pending)
+	}
+
+	fmt.Fprintln(out, "}")
+	fmt.Fprintln(out)
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
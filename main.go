@@ -9,55 +9,159 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
 func main() {
 	flag.BoolVar(&shouldEchoBack, "echo", true, "Echo (note) input back to midi source")
 	flag.IntVar(&echoVelocity, "echovel", 2, "Velocity to use for the echo")
-	flag.IntVar(&keySignature, "key", 0, "How many accidentals your key signature has (e.g. A Major would have *3* sharps)")
+	keyName := flag.String("key", "C", "Key to start in, e.g. Bb, f#m or \"D dorian\" (or how many accidentals your key signature has, e.g. A Major would have *3* sharps)")
 	fs := flag.Bool("flats", false, "Use flats (♭) instead of sharps (♯)")
 	f := flag.Bool("flat", false, "alias for -flats")
 	nogui := flag.Bool("nogui", false, "disable gui")
+	deviceNames := flag.String("device", "", "MIDI device(s) to use, as a path, a name in /dev or part of its description (see -list), separated by commas")
+	list := flag.Bool("list", false, "List available MIDI devices and exit")
+	autoKey := flag.Bool("autokey", false, "Detect the key from what is played and switch to it")
+	record := flag.String("record", "", "Record everything played to this MIDI file, saved on exit")
+	musicXML := flag.String("musicxml", "", "Write everything played to this MusicXML file on exit, quantized to -tempo and -time")
+	lilyPond := flag.String("lilypond", "", "Write everything played to this LilyPond file on exit, quantized to -tempo and -time")
+	abc := flag.String("abc", "", "Write everything played to this ABC file on exit, quantized to -tempo and -time; with -nogui it is also printed as you play")
+	play := flag.String("play", "", "Play this MIDI file instead of listening to a device")
+	playOut := flag.Bool("playout", false, "With -play, also send the file to the MIDI device(s) so they play it")
+	flag.BoolVar(&showTimeline, "timeline", false, "Scroll what was played across the staff instead of only showing what is held now")
+	flag.Float64Var(&beatsPerMinute, "tempo", beatsPerMinute, "Tempo for the timeline's barlines, in quarter notes per minute")
+	timeSigName := flag.String("time", timeSig.String(), "Time signature for the timeline's barlines")
+	flag.IntVar(&timelineMeasures, "measures", timelineMeasures, "How many measures the timeline shows")
+	flag.StringVar(&snapshotPath, "snapshot", "", "Draw the staff to this .svg or .png file: the notes given after the flags (e.g. C4 E4 G4) and exit, or else whatever is held, redrawn as you play (SVG only)")
+	backend := flag.String("backend", "raw", "Where to read MIDI from: raw (device files in /dev) or alsa (ALSA sequencer, -device then takes ports like 20:0)")
 
 	flag.Parse()
 
-	if keySignature > 7 {
-		keySignature = 7
-	}
-	if keySignature < 0 {
-		keySignature = 0
-	}
+	setUseFlats(*f || *fs)
 
-	useFlats = *f || *fs
+	key, err := parseKey(*keyName, useFlats())
+	if err != nil {
+		fmt.Println("Invalid key", *keyName+":", err)
+		os.Exit(2)
+	}
+	setKey(key)
+	fmt.Println("# Key:", currentKey(), "#")
+	detector.setEnabled(*autoKey)
 
 	if *nogui {
 		useGUI = false
 	}
 
-	devs, err := os.Open("/dev")
-	assertOK(err)
-	allDevices, err := devs.Readdirnames(0)
-	assertOK(err)
+	timeSig, err = parseTimeSignature(*timeSigName)
+	if err != nil {
+		fmt.Println("Invalid time signature", *timeSigName+":", err)
+		os.Exit(2)
+	}
+	if beatsPerMinute <= 0 || timelineMeasures < 1 {
+		fmt.Println("The tempo and number of measures have to be positive")
+		os.Exit(2)
+	}
+	if showTimeline {
+		historyLength = timelineWindow()
+	}
 
-	name := scanForDevices(allDevices)
+	if snapshotPath != "" && flag.NArg() > 0 {
+		notes := []byte{}
+		for _, arg := range flag.Args() {
+			n, err := parseNote(arg)
+			if err != nil {
+				fmt.Println("Invalid note", arg+":", err)
+				os.Exit(2)
+			}
+			notes = append(notes, n)
+		}
 
-	for name == "" {
-		devs.Close()
-		fmt.Println("Unable to find any midi devices in /dev.")
-		fmt.Println("Check connection to device, then press enter to try again, or ^C to cancel.")
-		fmt.Scanln()
-		devs, _ := os.Open("/dev")
-		allDevices, _ = devs.Readdirnames(0)
-		name = scanForDevices(allDevices)
+		pressNotes(notes)
+		if err := writeSnapshot(snapshotPath); err != nil {
+			fmt.Println("Unable to save", snapshotPath+":", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if isPNG(snapshotPath) {
+		fmt.Println("Only SVG snapshots can follow what is played, give the notes to draw a PNG")
+		os.Exit(2)
 	}
 
-	dev, err := os.OpenFile("/dev/"+name, os.O_RDWR, os.ModeDevice)
-	assertOK(err)
-	devs.Close()
+	if *backend != "raw" && *backend != "alsa" {
+		fmt.Println("Unknown backend", *backend+", use raw or alsa.")
+		os.Exit(2)
+	}
+	if *backend == "alsa" {
+		if err := alsaAvailable(); err != nil {
+			fmt.Println("Unable to use the ALSA backend:", err)
+			os.Exit(2)
+		}
+	}
+
+	if *list {
+		if *backend == "alsa" {
+			printAlsaPorts()
+		} else {
+			printDevices()
+		}
+		return
+	}
 
-	fmt.Println("# Found MIDI device /dev/"+name, "#")
+	wanted := []string{}
+	if *deviceNames != "" {
+		wanted = strings.Split(*deviceNames, ",")
+	}
 
-	midi := bufio.NewReader(dev)
+	//devices are only needed for playing a file if it should be sent to them
+	if *play == "" || *playOut {
+		if *backend == "alsa" {
+			addSources(openAlsaInputs(wanted))
+		} else {
+			addSources(openRawInputs(pickDevices(wanted), len(wanted) > 0))
+		}
+	}
+
+	if *play != "" {
+		player, err := newFilePlayer(*play)
+		if err != nil {
+			fmt.Println("Unable to play", *play+":", err)
+			os.Exit(1)
+		}
+
+		player.outputs = append(player.outputs, sources...)
+		addSources([]midiInput{player})
+
+		fmt.Println("# Playing", *play, "#")
+	}
+
+	if *record != "" || *musicXML != "" || *lilyPond != "" || *abc != "" {
+		recording = newRecorder()
+		if *record != "" {
+			recording.saveAs(*record, writeRecordingSMF)
+		}
+		if *musicXML != "" {
+			recording.saveAs(*musicXML, writeMusicXML)
+		}
+		if *lilyPond != "" {
+			recording.saveAs(*lilyPond, writeLilyPond)
+		}
+		if *abc != "" {
+			recording.saveAs(*abc, writeABC)
+			if !useGUI {
+				go printABC()
+			}
+		}
+		saveOnInterrupt()
+	}
+
+	events := make(chan midiEvent)
+	changes := make(chan *midiSource)
+	for _, source := range sources {
+		go source.listen(events, changes)
+	}
 
 	//Allow manual writes to the midi device
 	keyboardInput := listenForLines()
@@ -68,21 +172,54 @@ func main() {
 		for {
 			select {
 			case userInput := <-keyboardInput:
-				dev.Write(userInput)
-
-			default:
-				midiReadAndUpdateValues(midi, dev)
+				for _, source := range sources {
+					source.Write(userInput)
+				}
+
+			case ev := <-events:
+				handleEvent(ev)
+				if !useGUI {
+					//otherwise the GUI takes care of this, as it
+					//owns the key
+					detector.update()
+					updateSnapshot()
+				}
+
+			case source := <-changes:
+				if source.isConnected() {
+					reconnected(source)
+				} else {
+					disconnected(source)
+				}
 			}
 		}
 	}()
 
 	if useGUI {
 		raylibWindow()
+		if !saveRecording() {
+			os.Exit(1)
+		}
 		os.Exit(0)
 	} else {
 		fmt.Println("^D to quit")
 
 		for bufio.NewScanner(os.Stdin).Scan() {
 		}
+		if !saveRecording() {
+			os.Exit(1)
+		}
 	}
 }
+
+//saveOnInterrupt makes sure ^C doesn't lose the recording
+func saveOnInterrupt() {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-interrupt
+		saveRecording()
+		os.Exit(1)
+	}()
+}
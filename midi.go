@@ -16,30 +16,34 @@ import (
 const (
 	NOTE_OFF             = 0x80
 	NOTE_ON              = 0x90
+	POLY_PRESSURE        = 0xA0
 	CONTROL              = 0xB0
+	PROGRAM_CHANGE       = 0xC0
+	CHANNEL_PRESSURE     = 0xD0
+	PITCH_BEND           = 0xE0
 	SUSTAIN              = 0x40
 	SOSTENUTO            = 0x42
 	SOFT_PEDAL           = 0x43
 	SYSTEM               = 0xF0
 	SYSTEM_EXCLUSIVE     = 0xF0
+	TIME_CODE            = 0xF1
+	SONG_POSITION        = 0xF2
+	SONG_SELECT          = 0xF3
+	TUNE_REQUEST         = 0xF6
 	SYSTEM_END_EXCLUSIVE = 0xF7
+	TIMING_CLOCK         = 0xF8
+	START                = 0xFA
+	CONTINUE             = 0xFB
+	STOP                 = 0xFC
+	ACTIVE_SENSING       = 0xFE
+	RESET                = 0xFF
 )
 
 var (
 	//--- flags ---
 	shouldEchoBack bool
 	echoVelocity   int
-	useFlats       bool
 	useGUI         bool = true
-	keySignature   int
-
-	//---for raylib---
-	activeNotes  = []byte{}
-	notesToClear = []byte{}
-	//hasNewNote               = false
-	lastVelocity     byte    = 0
-	sustainPercent   float32 = 0
-	sostenutoPercent float32 = 0
 )
 
 func assertOK(err error) {
@@ -48,130 +52,96 @@ func assertOK(err error) {
 	}
 }
 
-func scanForDevices(allDevices []string) string {
-	for _, file := range allDevices {
-		if strings.HasPrefix(file, "midi") {
-			return file
-		}
-	}
-
-	return ""
-}
-
-func midiReadAndUpdateValues(midi *bufio.Reader, dev *os.File) {
-	msg, err := midi.ReadByte()
-	assertOK(err)
-
-	if msg == 0xFE {
+func handleEvent(ev midiEvent) {
+	if ev.isRealtime() {
 		//safe to ignore
 		return
 	}
 
-	//get just the status number, low nibble is the midi channel
-	switch msg & 0xF0 {
+	if recording != nil {
+		recording.add(ev)
+	}
+
+	fmt.Print(sourcePrefix(ev.source))
+
+	switch ev.kind() {
 	case NOTE_ON:
-		note(msg, true, midi, dev)
+		//note on with velocity 0 is the same as note off, and it is what
+		//most keyboards send to make the most of running status
+		note(ev, ev.data[1] != 0)
 	case NOTE_OFF:
-		note(msg, false, midi, dev)
+		note(ev, false)
 
 	case CONTROL:
-		control(msg, midi)
-
-	case SYSTEM:
-		switch msg {
-		case SYSTEM_EXCLUSIVE:
-			fmt.Print("System exclusive message: [")
-			for {
-				b, _ := midi.ReadByte()
-				if b == SYSTEM_END_EXCLUSIVE {
-					break
-				}
-				fmt.Printf("%02X ", b)
-			}
-			fmt.Println("\b]")
+		control(ev)
+
+	case SYSTEM_EXCLUSIVE:
+		fmt.Print("System exclusive message: [")
+		for _, b := range ev.data {
+			fmt.Printf("%02X ", b)
 		}
+		fmt.Println("\b]")
+
 	default:
-		fmt.Printf("Byte %02X (%03d, %08b)\n", msg, msg, msg)
+		for _, b := range ev.bytes() {
+			fmt.Printf("Byte %02X (%03d, %08b)\n", b, b, b)
+		}
+	}
+}
+
+//sourcePrefix tags output with the device it came from, but only if there
+//is more than one to tell apart
+func sourcePrefix(source *midiSource) string {
+	if len(sources) < 2 || source == nil {
+		return ""
 	}
+
+	return fmt.Sprintf("[%s] ", source.name())
 }
 
-func note(msg byte, on bool, b *bufio.Reader, device *os.File) {
-	note, _ := b.ReadByte()
-	velocity, _ := b.ReadByte()
+func note(ev midiEvent, on bool) {
+	note, velocity := ev.data[0], ev.data[1]
 
 	fmt.Printf("Input   Channel %02d: Note %s %03d (%s) @ velocity %03d\n",
-		msg&0x0F,
+		ev.channel(),
 		map[bool]string{true: "on ", false: "off"}[on],
 		note,
-		noteName(note, useFlats),
+		noteName(note),
 		velocity,
 	)
 
 	if on {
-		activeNotes = append(activeNotes, note)
-		lastVelocity = velocity
-		//hasNewNote = true
+		state.noteOn(ev)
+		detector.noteOn(ev)
 	} else {
-		notesToClear = append(notesToClear, note)
-	}
-
-	if shouldEchoBack {
-		device.Write([]byte{msg, note, byte(echoVelocity)})
+		state.noteOff(ev)
+		detector.noteOff(ev)
 	}
-}
-
-func noteName(midiValue byte, flats bool) string {
-	noteLetter := map[bool][]string{
-		false: {"C♮", "C♯", "D♮", "D♯", "E♮", "F♮", "F♯", "G♮", "G♯", "A♮", "A♯", "B♮"},
-		true:  {"C♮", "D♭", "D♮", "E♭", "E♮", "F♮", "G♭", "G♮", "A♭", "A♮", "B♭", "B♮"},
-	}[flats][midiValue%12]
+	printHarmony()
 
-	//integer divide; - 1 because midi says so (octaves start at -1)
-	octave := midiValue/12 - 1
-
-	return fmt.Sprintf("%s-%d", noteLetter, octave)
-}
-
-func isOnLine(note byte) bool {
-	//look up table - naturals
-	switch note {
-	case 23, 26, 29, 33, 36, 40, 43, 47, 50, 53, 57, 60,
-		64, 67, 71, 74, 77, 81, 84, 88, 91, 95, 98, 101, 105, 108:
-		return true
-	}
-
-	if !useFlats {
-		//sharps
-		switch note {
-		case 27, 30, 34, 37, 44, 51, 54, 58, 61,
-			68, 75, 78, 82, 85, 92, 99, 102, 106:
-			return true
-		}
-	} else {
-		//flats
-		switch note {
-		case 22, 25, 32, 39, 42, 46, 49, 56, 63,
-			66, 70, 73, 80, 87, 90, 94, 97, 104:
-			return true
+	if shouldEchoBack && ev.source != nil {
+		//a note on with velocity 0 is a release, and has to stay one
+		echo := byte(echoVelocity)
+		if ev.kind() == NOTE_ON && velocity == 0 {
+			echo = 0
 		}
+		ev.source.Write([]byte{ev.status, note, echo})
 	}
-
-	return false
 }
 
-func control(msg byte, b *bufio.Reader) {
-	ctrl, _ := b.ReadByte()
-	value, _ := b.ReadByte()
+func control(ev midiEvent) {
+	ctrl, value := ev.data[0], ev.data[1]
+
+	state.control(ev)
+	defer printHarmony()
 
-	fmt.Printf("Control Channel %02d: ", msg&0x0F)
+	fmt.Printf("Control Channel %02d: ", ev.channel())
 
 	switch ctrl {
 	case SUSTAIN:
-		sustainPercent = float32(value) / 127
 		fmt.Printf("Sustain @ %06.2f%% (%02X)\n", 100*(float32(value)/127), value)
 
 	case SOSTENUTO:
-		sostenutoPercent = float32(value) / 127
 		fmt.Printf("Sostenuto %s\n",
 			map[bool]string{
 				true:  "on",
@@ -187,7 +157,7 @@ func control(msg byte, b *bufio.Reader) {
 		)
 
 	default:
-		fmt.Printf("Byte %02X (%03d, %08b)\n", msg, msg, msg)
+		fmt.Printf("Byte %02X (%03d, %08b)\n", ev.status, ev.status, ev.status)
 		fmt.Printf("Byte %02X (%03d, %08b)\n", ctrl, ctrl, ctrl)
 		fmt.Printf("Byte %02X (%03d, %08b)\n", value, value, value)
 	}
@@ -0,0 +1,218 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+//MusicXML's names for the note values, by base value
+var musicXMLTypes = map[int]string{
+	1:  "whole",
+	2:  "half",
+	4:  "quarter",
+	8:  "eighth",
+	16: "16th",
+	32: "32nd",
+}
+
+//writeMusicXML writes the recording as a partwise MusicXML score for piano,
+//with the treble and bass staff as two voices of one part
+func writeMusicXML(w io.Writer, r *recorder) error {
+	s := r.session()
+	treble, bass := s.staves()
+	staves := [][][]notatedChord{
+		s.measures(treble, s.measureCount()),
+		s.measures(bass, s.measureCount()),
+	}
+
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>`)
+	fmt.Fprintln(out, `<!DOCTYPE score-partwise PUBLIC "-//Recordare//DTD MusicXML 3.1 Partwise//EN" "http://www.musicxml.org/dtds/partwise.dtd">`)
+	fmt.Fprintln(out, `<score-partwise version="3.1">`)
+	fmt.Fprintln(out, `  <part-list>`)
+	fmt.Fprintln(out, `    <score-part id="P1">`)
+	fmt.Fprintln(out, `      <part-name>Piano</part-name>`)
+	fmt.Fprintln(out, `    </score-part>`)
+	fmt.Fprintln(out, `  </part-list>`)
+	fmt.Fprintln(out, `  <part id="P1">`)
+
+	for m := 0; m < s.measureCount(); m++ {
+		fmt.Fprintf(out, "    <measure number=\"%d\">\n", m+1)
+		if m == 0 {
+			writeMusicXMLAttributes(out)
+		}
+
+		//how far the last staff went into the measure
+		written := 0
+		for staff, measures := range staves {
+			if written > 0 {
+				fmt.Fprintln(out, "      <backup>")
+				fmt.Fprintf(out, "        <duration>%d</duration>\n", written)
+				fmt.Fprintln(out, "      </backup>")
+			}
+
+			written = 0
+			for _, c := range measures[m] {
+				writeMusicXMLChord(out, c, staff+1)
+				written += c.value.ticks
+			}
+		}
+
+		writeMusicXMLPedals(out, s, m, written)
+
+		fmt.Fprintln(out, "    </measure>")
+	}
+
+	fmt.Fprintln(out, `  </part>`)
+	fmt.Fprintln(out, `</score-partwise>`)
+
+	return out.Flush()
+}
+
+func writeMusicXMLAttributes(out io.Writer) {
+	fmt.Fprintln(out, "      <attributes>")
+	fmt.Fprintf(out, "        <divisions>%d</divisions>\n", ticksPerQuarter)
+	fmt.Fprintln(out, "        <key>")
+	fmt.Fprintf(out, "          <fifths>%d</fifths>\n", currentKey().fifths())
+	fmt.Fprintf(out, "          <mode>%s</mode>\n", modeNames[currentKey().mode])
+	fmt.Fprintln(out, "        </key>")
+	fmt.Fprintln(out, "        <time>")
+	fmt.Fprintf(out, "          <beats>%d</beats>\n", timeSig.beats)
+	fmt.Fprintf(out, "          <beat-type>%d</beat-type>\n", timeSig.unit)
+	fmt.Fprintln(out, "        </time>")
+	fmt.Fprintln(out, "        <staves>2</staves>")
+	fmt.Fprintln(out, `        <clef number="1">`)
+	fmt.Fprintln(out, "          <sign>G</sign>")
+	fmt.Fprintln(out, "          <line>2</line>")
+	fmt.Fprintln(out, "        </clef>")
+	fmt.Fprintln(out, `        <clef number="2">`)
+	fmt.Fprintln(out, "          <sign>F</sign>")
+	fmt.Fprintln(out, "          <line>4</line>")
+	fmt.Fprintln(out, "        </clef>")
+	fmt.Fprintln(out, "      </attributes>")
+}
+
+//writeMusicXMLChord writes a chord as one note element per note, all but
+//the first marked as part of the chord, or a rest
+func writeMusicXMLChord(out io.Writer, c notatedChord, staff int) {
+	if c.isRest() {
+		fmt.Fprintln(out, "      <note>")
+		fmt.Fprintln(out, "        <rest/>")
+		writeMusicXMLValue(out, c.value, false, false, staff)
+		fmt.Fprintln(out, "      </note>")
+		return
+	}
+
+	//low to high, like most programs write them
+	for i, p := range c.notes {
+		spelled := spell(p.note)
+
+		fmt.Fprintln(out, "      <note>")
+		if i > 0 {
+			fmt.Fprintln(out, "        <chord/>")
+		}
+		fmt.Fprintln(out, "        <pitch>")
+		fmt.Fprintf(out, "          <step>%c</step>\n", spelled.letter)
+		if spelled.alter != 0 {
+			fmt.Fprintf(out, "          <alter>%d</alter>\n", spelled.alter)
+		}
+		fmt.Fprintf(out, "          <octave>%d</octave>\n", spelled.octave)
+		fmt.Fprintln(out, "        </pitch>")
+		writeMusicXMLValue(out, c.value, c.tiedFromPrevious[i], c.tiedToNext[i], staff)
+		fmt.Fprintln(out, "      </note>")
+	}
+}
+
+//writeMusicXMLValue writes everything about a note's duration, ties
+//included, up to the staff it goes on
+func writeMusicXMLValue(out io.Writer, v noteValue, tiedFromPrevious, tiedToNext bool, staff int) {
+	fmt.Fprintf(out, "        <duration>%d</duration>\n", v.ticks)
+	if tiedFromPrevious {
+		fmt.Fprintln(out, `        <tie type="stop"/>`)
+	}
+	if tiedToNext {
+		fmt.Fprintln(out, `        <tie type="start"/>`)
+	}
+
+	//a voice per staff
+	fmt.Fprintf(out, "        <voice>%d</voice>\n", staff)
+	fmt.Fprintf(out, "        <type>%s</type>\n", musicXMLTypes[v.base])
+	for i := 0; i < v.dots; i++ {
+		fmt.Fprintln(out, "        <dot/>")
+	}
+	if v.triplet {
+		fmt.Fprintln(out, "        <time-modification>")
+		fmt.Fprintln(out, "          <actual-notes>3</actual-notes>")
+		fmt.Fprintln(out, "          <normal-notes>2</normal-notes>")
+		fmt.Fprintln(out, "        </time-modification>")
+	}
+	fmt.Fprintf(out, "        <staff>%d</staff>\n", staff)
+
+	if tiedFromPrevious || tiedToNext {
+		fmt.Fprintln(out, "        <notations>")
+		if tiedFromPrevious {
+			fmt.Fprintln(out, `          <tied type="stop"/>`)
+		}
+		if tiedToNext {
+			fmt.Fprintln(out, `          <tied type="start"/>`)
+		}
+		fmt.Fprintln(out, "        </notations>")
+	}
+}
+
+//writeMusicXMLPedals writes the pedal changes of a measure under the bass
+//staff. Everything else has been written by now, up to position, so this
+//goes back from there to each change.
+func writeMusicXMLPedals(out io.Writer, s session, m int, position int) {
+	measure := measureTicks()
+
+	for _, change := range s.pedals {
+		at := quantize(s.start, change.at)
+		if at < 0 {
+			at = 0
+		}
+		if at/measure != m {
+			continue
+		}
+		at -= m * measure
+
+		if at < position {
+			fmt.Fprintln(out, "      <backup>")
+			fmt.Fprintf(out, "        <duration>%d</duration>\n", position-at)
+			fmt.Fprintln(out, "      </backup>")
+		} else if at > position {
+			fmt.Fprintln(out, "      <forward>")
+			fmt.Fprintf(out, "        <duration>%d</duration>\n", at-position)
+			fmt.Fprintln(out, "      </forward>")
+		}
+		position = at
+
+		fmt.Fprintln(out, `      <direction placement="below">`)
+		fmt.Fprintln(out, "        <direction-type>")
+		switch {
+		case change.controller == SUSTAIN && change.down:
+			fmt.Fprintln(out, `          <pedal type="start" line="no" sign="yes"/>`)
+		case change.controller == SUSTAIN:
+			fmt.Fprintln(out, `          <pedal type="stop" line="no" sign="yes"/>`)
+		case change.down:
+			fmt.Fprintln(out, "          <words>Sost. Ped.</words>")
+		default:
+			fmt.Fprintln(out, "          <words>*</words>")
+		}
+		fmt.Fprintln(out, "        </direction-type>")
+		fmt.Fprintln(out, "        <staff>2</staff>")
+		fmt.Fprintln(out, "      </direction>")
+	}
+
+	if position < measure {
+		fmt.Fprintln(out, "      <forward>")
+		fmt.Fprintf(out, "        <duration>%d</duration>\n", measure-position)
+		fmt.Fprintln(out, "      </forward>")
+	}
+}
@@ -0,0 +1,118 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+//go test -update writes the golden files again from what the code does now
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+//testRecording records notes and pedal changes (down at from, up at to) as
+//if they had been played
+func testRecording(notes []playedNote, pedals []playedNote) *recorder {
+	r := newRecorder()
+
+	events := []midiEvent{}
+	for _, p := range notes {
+		events = append(events,
+			midiEvent{status: NOTE_ON, data: []byte{p.note, p.velocity}, time: p.onset},
+			midiEvent{status: NOTE_OFF, data: []byte{p.note, 0}, time: p.release},
+		)
+	}
+	for _, p := range pedals {
+		events = append(events,
+			midiEvent{status: CONTROL, data: []byte{p.note, 127}, time: p.onset},
+			midiEvent{status: CONTROL, data: []byte{p.note, 0}, time: p.release},
+		)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].time.Before(events[j].time)
+	})
+	for _, ev := range events {
+		r.add(ev)
+	}
+
+	return r
+}
+
+func TestWriteMusicXML(t *testing.T) {
+	defer inKeyOf(t, "C")()
+
+	tests := []struct {
+		name   string
+		notes  []playedNote
+		pedals []playedNote
+	}{
+		{
+			//C held on under E and G, over a whole note in the bass
+			"held-notes",
+			[]playedNote{
+				testNote(60, 0, 48),
+				testNote(64, 0, 24),
+				testNote(67, 24, 48),
+				testNote(72, 48, 96),
+				testNote(48, 0, 96),
+			},
+			[]playedNote{testNote(SUSTAIN, 0, 84)},
+		},
+		{
+			//eighth triplets, and nothing at all in the bass
+			"triplets",
+			[]playedNote{
+				testNote(72, 0, 8),
+				testNote(74, 8, 16),
+				testNote(76, 16, 24),
+				testNote(77, 24, 48),
+				testNote(79, 48, 64),
+				testNote(81, 64, 72),
+				testNote(83, 72, 102),
+			},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		out := bytes.Buffer{}
+		if err := writeMusicXML(&out, testRecording(test.notes, test.pedals)); err != nil {
+			t.Fatal(err)
+		}
+
+		decoder := xml.NewDecoder(bytes.NewReader(out.Bytes()))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+
+		path := filepath.Join("testdata", test.name+".musicxml")
+		if *update {
+			if err := ioutil.WriteFile(path, out.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("%s: output differs from %s:\n%s", test.name, path, out.String())
+		}
+	}
+}
@@ -0,0 +1,174 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//scheduledEvent is an event from a midi file together with when to play it,
+//counted from the start of the file
+type scheduledEvent struct {
+	at time.Duration
+	ev midiEvent
+}
+
+//filePlayer plays a midi file as if it was a device being played on
+type filePlayer struct {
+	path   string
+	events []scheduledEvent
+
+	start time.Time
+	pos   int
+
+	//devices to send the events to as well, so they play the file
+	outputs []*midiSource
+}
+
+func newFilePlayer(path string) (*filePlayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	smf, err := parseSMF(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &filePlayer{
+		path:   path,
+		events: schedule(smf),
+	}, nil
+}
+
+//schedule merges all tracks into a single timeline and turns ticks into
+//real time, following tempo changes along the way
+func schedule(smf smfFile) []scheduledEvent {
+	all := []smfEvent{}
+	for _, track := range smf.tracks {
+		all = append(all, track...)
+	}
+	//stable, so events at the same time stay in track order
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].ticks < all[j].ticks
+	})
+
+	//tickLength returns how long a tick is at a given tempo
+	tickLength := func(microsPerQuarter uint32) time.Duration {
+		return time.Duration(microsPerQuarter) * time.Microsecond / time.Duration(smf.division)
+	}
+
+	if smf.division&0x8000 != 0 {
+		//SMPTE timing: negative frames per second in the high byte,
+		//ticks per frame in the low byte. Tempo events don't matter.
+		framesPerSecond := time.Duration(-int8(smf.division >> 8))
+		ticksPerFrame := time.Duration(smf.division & 0xFF)
+		length := time.Second / (framesPerSecond * ticksPerFrame)
+
+		tickLength = func(uint32) time.Duration {
+			return length
+		}
+	}
+
+	ret := []scheduledEvent{}
+
+	tempo := uint32(smfDefaultTempo)
+	lastTicks := uint32(0)
+	at := time.Duration(0)
+
+	for _, e := range all {
+		at += time.Duration(e.ticks-lastTicks) * tickLength(tempo)
+		lastTicks = e.ticks
+
+		status := e.data[0]
+		switch {
+		case status == META:
+			if e.data[1] == META_TEMPO && len(e.data) >= 6 {
+				tempo = uint32(e.data[3])<<16 | uint32(e.data[4])<<8 | uint32(e.data[5])
+			}
+
+		case status == SYSTEM_EXCLUSIVE:
+			//F0 <length> <data...> F7, we want just the data
+			payload := skipVarLen(e.data[1:])
+			if len(payload) > 0 && payload[len(payload)-1] == SYSTEM_END_EXCLUSIVE {
+				payload = payload[:len(payload)-1]
+			}
+			ret = append(ret, scheduledEvent{
+				at: at,
+				ev: midiEvent{status: SYSTEM_EXCLUSIVE, data: payload},
+			})
+
+		case status < SYSTEM:
+			ret = append(ret, scheduledEvent{
+				at: at,
+				ev: midiEvent{status: status, data: e.data[1:]},
+			})
+		}
+	}
+
+	return ret
+}
+
+func skipVarLen(b []byte) []byte {
+	for i, x := range b {
+		if x&0x80 == 0 {
+			return b[i+1:]
+		}
+	}
+
+	return nil
+}
+
+//next waits until the next event is due. Once the file is over it blocks
+//forever, the display just stays as it is.
+func (p *filePlayer) next() (midiEvent, error) {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+
+	if p.pos >= len(p.events) {
+		fmt.Println("# Finished playing", p.path, "#")
+		select {}
+	}
+
+	e := p.events[p.pos]
+	p.pos++
+
+	time.Sleep(time.Until(p.start.Add(e.at)))
+
+	for _, output := range p.outputs {
+		output.Write(e.ev.bytes())
+	}
+
+	return e.ev, nil
+}
+
+//Write throws away whatever is sent to the file, like the echo
+func (p *filePlayer) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (p *filePlayer) Close() error {
+	return nil
+}
+
+func (p *filePlayer) name() string {
+	return filepath.Base(p.path)
+}
+
+func (p *filePlayer) description() string {
+	return p.path
+}
+
+//reopen is never needed, next doesn't return errors
+func (p *filePlayer) reopen() midiInput {
+	return p
+}
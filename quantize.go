@@ -0,0 +1,373 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+//ticksPerQuarter is fine enough for 32nd notes (3 ticks) as well as eighth
+//and sixteenth triplets (8 and 4 ticks)
+const ticksPerQuarter = 24
+
+//noteValue is how a duration is written: the kind of note (1 for a whole
+//note, 4 for a quarter, 32 for a 32nd) with dots, or as part of a triplet
+type noteValue struct {
+	ticks   int
+	base    int
+	dots    int
+	triplet bool
+}
+
+//every value that can be written, longest first
+var noteValues = []noteValue{
+	{96, 1, 0, false},
+	{72, 2, 1, false},
+	{48, 2, 0, false},
+	{36, 4, 1, false},
+	{32, 2, 0, true},
+	{24, 4, 0, false},
+	{18, 8, 1, false},
+	{16, 4, 0, true},
+	{12, 8, 0, false},
+	{9, 16, 1, false},
+	{8, 8, 0, true},
+	{6, 16, 0, false},
+	{4, 16, 0, true},
+	{3, 32, 0, false},
+}
+
+//notatedChord is one note value on a staff: a chord, a single note or a
+//rest if there are no notes
+type notatedChord struct {
+	//in ticks from the first note that was played
+	start int
+	value noteValue
+	//from lowest to highest
+	notes []playedNote
+	//which of the notes are held on into the next chord, by index
+	tiedToNext []bool
+	//and which of them are held on from the previous chord
+	tiedFromPrevious []bool
+}
+
+func (c notatedChord) isRest() bool {
+	return len(c.notes) == 0
+}
+
+//struck reports whether any of the notes is played here, rather than only
+//held on from before
+func (c notatedChord) struck() bool {
+	for _, tied := range c.tiedFromPrevious {
+		if !tied {
+			return true
+		}
+	}
+
+	return false
+}
+
+func measureTicks() int {
+	return ticksPerQuarter * 4 * timeSig.beats / timeSig.unit
+}
+
+//exactTicks is how far a moment is from the first note, in ticks
+func exactTicks(firstOnset, t time.Time) float64 {
+	return float64(t.Sub(firstOnset)) / float64(quarterLength()) * ticksPerQuarter
+}
+
+//quantize snaps a moment to the closest 32nd note, counting from the first
+//note. Notes snap to a grid instead, which knows about triplets.
+func quantize(firstOnset, t time.Time) int {
+	return 3 * int(math.Round(exactTicks(firstOnset, t)/3))
+}
+
+//tickTime is the moment a tick stands for
+func tickTime(firstOnset time.Time, ticks int) time.Time {
+	return firstOnset.Add(time.Duration(ticks) * quarterLength() / ticksPerQuarter)
+}
+
+//grid is what notes snap to: 32nd notes, or eighth triplets in the beats
+//that are played as triplets. Starts and ends snap to the same grid, so
+//whatever lies in between can always be written.
+type grid struct {
+	firstOnset time.Time
+	//by quarter, counting from the first note
+	triplets map[int]bool
+}
+
+//newGrid decides which beats are triplets: those where the notes start and
+//end a lot closer to eighth triplets than to 32nds. Beats cut in half by a
+//barline, as in 3/8, never are.
+func newGrid(firstOnset time.Time, notes []playedNote) grid {
+	g := grid{firstOnset, map[int]bool{}}
+	straightError, tripletError := map[int]float64{}, map[int]float64{}
+
+	for _, p := range notes {
+		for _, t := range []time.Time{p.onset, p.release} {
+			exact := exactTicks(firstOnset, t)
+			beat := int(math.Floor(exact / ticksPerQuarter))
+			straightError[beat] += math.Abs(3*math.Round(exact/3) - exact)
+			tripletError[beat] += math.Abs(8*math.Round(exact/8) - exact)
+		}
+	}
+
+	measure := measureTicks()
+	for beat, e := range tripletError {
+		start := beat * ticksPerQuarter
+		fits := beat >= 0 && start/measure == (start+ticksPerQuarter-1)/measure
+		if fits && e < straightError[beat]/2 {
+			g.triplets[beat] = true
+		}
+	}
+
+	return g
+}
+
+//snap is the closest tick on the grid to a moment
+func (g grid) snap(t time.Time) int {
+	exact := exactTicks(g.firstOnset, t)
+	if g.triplets[int(math.Floor(exact/ticksPerQuarter))] {
+		return 8 * int(math.Round(exact/8))
+	}
+
+	return quantize(g.firstOnset, t)
+}
+
+//unit is the shortest note that can start at a tick
+func (g grid) unit(ticks int) int {
+	if g.triplets[ticks/ticksPerQuarter] {
+		return 8
+	}
+
+	return 3
+}
+
+//splitValues writes a duration as note values to be tied together, longest
+//first. Durations on the grid that don't cross a triplet beat always add up:
+//multiples of 3 ticks, or of 8 within a triplet beat.
+func splitValues(ticks int) []noteValue {
+	ret := []noteValue{}
+
+	for ticks > 0 {
+		//triplets only for what can't be written without them
+		triplet := ticks%3 != 0
+
+		found := false
+		for _, v := range noteValues {
+			if v.ticks <= ticks && v.triplet == triplet {
+				ret = append(ret, v)
+				ticks -= v.ticks
+				found = true
+				break
+			}
+		}
+		if !found {
+			panic(fmt.Sprint("assertion failed: no note value fits ", ticks, " ticks"))
+		}
+	}
+
+	return ret
+}
+
+//notate turns the notes played on one staff into note values. Every start
+//and end of a note begins a new chord of everything that sounds until the
+//next one, so notes held on while others are played are tied into the
+//chords that follow. Where nothing sounds, rests fill the gap.
+func notate(played []playedNote, g grid) []notatedChord {
+	type span struct {
+		start, end int
+		played     playedNote
+	}
+
+	sorted := append([]playedNote{}, played...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].onset.Before(sorted[j].onset)
+	})
+
+	spans := []span{}
+	cuts := map[int]bool{}
+	for _, p := range sorted {
+		start := g.snap(p.onset)
+		end := g.snap(p.release)
+		if end <= start {
+			end = start + g.unit(start)
+		}
+
+		spans = append(spans, span{start, end, p})
+		cuts[start] = true
+		cuts[end] = true
+	}
+
+	times := []int{}
+	for t := range cuts {
+		times = append(times, t)
+	}
+	sort.Ints(times)
+
+	//which spans sound between each cut and the next, by index in spans.
+	//A key played again while it still sounds starts over.
+	sounding := make([][]int, len(times))
+	for i := 0; i+1 < len(times); i++ {
+		byNote := map[byte]int{}
+		for j, s := range spans {
+			if s.start <= times[i] && s.end >= times[i+1] {
+				byNote[s.played.note] = j
+			}
+		}
+
+		for _, j := range byNote {
+			sounding[i] = append(sounding[i], j)
+		}
+		sort.Slice(sounding[i], func(a, b int) bool {
+			return spans[sounding[i][a]].played.note < spans[sounding[i][b]].played.note
+		})
+	}
+
+	contains := func(i, j int) bool {
+		if i < 0 || i >= len(sounding) {
+			return false
+		}
+		for _, k := range sounding[i] {
+			if k == j {
+				return true
+			}
+		}
+		return false
+	}
+
+	ret := []notatedChord{}
+	for i := 0; i+1 < len(times); i++ {
+		notes := []playedNote{}
+		from, to := []bool{}, []bool{}
+		for _, j := range sounding[i] {
+			notes = append(notes, spans[j].played)
+			from = append(from, contains(i-1, j))
+			to = append(to, contains(i+1, j))
+		}
+
+		ret = append(ret, g.notateSpan(times[i], times[i+1], notes, from, to)...)
+	}
+
+	return ret
+}
+
+//notateSpan writes notes (or a rest, if there are none) from start to end.
+//They are split at the barlines and around triplet beats and tied back
+//together, and tiedFromPrevious and tiedToNext say which of them are tied
+//to the chords before and after.
+func (g grid) notateSpan(start, end int, notes []playedNote, tiedFromPrevious, tiedToNext []bool) []notatedChord {
+	ret := []notatedChord{}
+	measure := measureTicks()
+
+	for start < end {
+		pieceEnd := (start/measure + 1) * measure
+		if pieceEnd > end {
+			pieceEnd = end
+		}
+		//whatever starts or ends inside a triplet beat is cut at its edge,
+		//so that the triplets stay in their group
+		if g.triplets[start/ticksPerQuarter] && start%ticksPerQuarter != 0 {
+			if beatEnd := (start/ticksPerQuarter + 1) * ticksPerQuarter; beatEnd < pieceEnd {
+				pieceEnd = beatEnd
+			}
+		} else if g.triplets[pieceEnd/ticksPerQuarter] && pieceEnd%ticksPerQuarter != 0 {
+			if beatStart := pieceEnd / ticksPerQuarter * ticksPerQuarter; beatStart > start {
+				pieceEnd = beatStart
+			}
+		}
+
+		for _, v := range splitValues(pieceEnd - start) {
+			ret = append(ret, notatedChord{
+				start:            start,
+				value:            v,
+				notes:            notes,
+				tiedToNext:       allTied(len(notes)),
+				tiedFromPrevious: allTied(len(notes)),
+			})
+			start += v.ticks
+		}
+	}
+
+	if len(ret) > 0 && len(notes) > 0 {
+		ret[0].tiedFromPrevious = tiedFromPrevious
+		ret[len(ret)-1].tiedToNext = tiedToNext
+	}
+
+	return ret
+}
+
+func allTied(n int) []bool {
+	ret := make([]bool, n)
+	for i := range ret {
+		ret[i] = true
+	}
+
+	return ret
+}
+
+//beamLength is how much music a beam may span: a quarter, or a dotted
+//quarter in compound time like 6/8
+func beamLength() int {
+	if timeSig.unit >= 8 && timeSig.beats%3 == 0 {
+		return 3 * ticksPerQuarter * 4 / timeSig.unit
+	}
+
+	return ticksPerQuarter
+}
+
+//beamLevels is how many beams a note value gets: one for eighths, two for
+//sixteenths and so on. Longer values get none.
+func (v noteValue) beamLevels() int {
+	switch v.base {
+	case 8:
+		return 1
+	case 16:
+		return 2
+	case 32:
+		return 3
+	}
+
+	return 0
+}
+
+//beamGroups returns which chords are beamed together, as lists of indices.
+//Beams connect eighths and shorter within one beat and are broken by rests
+//and longer notes.
+func beamGroups(chords []notatedChord) [][]int {
+	ret := [][]int{}
+	length := beamLength()
+
+	group := []int{}
+	flush := func() {
+		if len(group) >= 2 {
+			ret = append(ret, group)
+		}
+		group = []int{}
+	}
+
+	for i, c := range chords {
+		beat := c.start / length
+		fitsInBeat := (c.start+c.value.ticks-1)/length == beat
+		if c.isRest() || c.value.beamLevels() == 0 || !fitsInBeat {
+			flush()
+			continue
+		}
+
+		if len(group) > 0 {
+			last := chords[group[len(group)-1]]
+			if last.start/length != beat || last.start+last.value.ticks != c.start {
+				flush()
+			}
+		}
+		group = append(group, i)
+	}
+	flush()
+
+	return ret
+}
@@ -0,0 +1,247 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+//testStart is where the first note of the sessions in these tests is played
+var testStart = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+//atTick is the moment a tick stands for, which doesn't have to be a whole one
+func atTick(ticks float64) time.Time {
+	return testStart.Add(time.Duration(ticks * float64(quarterLength()) / ticksPerQuarter))
+}
+
+func testNote(note byte, from, to float64) playedNote {
+	return playedNote{
+		note:     note,
+		velocity: 64,
+		onset:    atTick(from),
+		release:  atTick(to),
+	}
+}
+
+func TestSplitValues(t *testing.T) {
+	tests := []struct {
+		ticks int
+		want  []int
+	}{
+		{96, []int{96}},
+		{93, []int{72, 18, 3}},
+		{45, []int{36, 9}},
+		{24, []int{24}},
+		{16, []int{16}},
+		{8, []int{8}},
+		{3, []int{3}},
+	}
+
+	for _, test := range tests {
+		got := []int{}
+		for _, v := range splitValues(test.ticks) {
+			got = append(got, v.ticks)
+		}
+
+		if len(got) != len(test.want) {
+			t.Errorf("%d: got %v, want %v", test.ticks, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%d: got %v, want %v", test.ticks, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+//checkMeasures fails the test unless every measure adds up, triplets only
+//come in complete groups within a beat and every tie ends on the same note
+func checkMeasures(t *testing.T, measures [][]notatedChord) {
+	t.Helper()
+
+	var previous *notatedChord
+	for m, measure := range measures {
+		total := 0
+		for _, c := range measure {
+			if c.start != m*measureTicks()+total {
+				t.Fatalf("measure %d: chord at %d after %d ticks", m, c.start, total)
+			}
+			total += c.value.ticks
+		}
+		if total != measureTicks() {
+			t.Fatalf("measure %d adds up to %d ticks", m, total)
+		}
+
+		for i := 0; i < len(measure); i++ {
+			if !measure[i].value.triplet {
+				continue
+			}
+			if measure[i].start%ticksPerQuarter != 0 {
+				t.Fatalf("measure %d: triplet group starts at %d", m, measure[i].start)
+			}
+
+			group := 0
+			for ; i < len(measure) && measure[i].value.triplet && group < ticksPerQuarter; i++ {
+				group += measure[i].value.ticks
+			}
+			i--
+			if group != ticksPerQuarter {
+				t.Fatalf("measure %d: incomplete triplet group of %d ticks", m, group)
+			}
+		}
+
+		for i := range measure {
+			c := measure[i]
+			for n, p := range c.notes {
+				if !c.tiedFromPrevious[n] {
+					continue
+				}
+				if previous == nil || !tiedTo(*previous, p.note) {
+					t.Fatalf("measure %d: %d at %d is tied from nothing", m, p.note, c.start)
+				}
+			}
+			if previous != nil {
+				for n, p := range previous.notes {
+					if previous.tiedToNext[n] && !tiedFrom(c, p.note) {
+						t.Fatalf("measure %d: %d at %d is tied to nothing", m, p.note, previous.start)
+					}
+				}
+			}
+			previous = &measure[i]
+		}
+	}
+}
+
+func tiedTo(c notatedChord, note byte) bool {
+	for i, p := range c.notes {
+		if p.note == note && c.tiedToNext[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func tiedFrom(c notatedChord, note byte) bool {
+	for i, p := range c.notes {
+		if p.note == note && c.tiedFromPrevious[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMeasuresAddUp(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	defer func(previous timeSignature) {
+		timeSig = previous
+	}(timeSig)
+
+	for _, sig := range []timeSignature{{4, 4}, {3, 4}, {6, 8}, {3, 8}, {5, 8}} {
+		timeSig = sig
+
+		for round := 0; round < 200; round++ {
+			s := session{start: testStart}
+			at := 0.0
+			for i := 0; i < 30; i++ {
+				length := random.Float64() * 60
+				s.notes = append(s.notes, testNote(byte(36+random.Intn(48)), at, at+length))
+				if random.Intn(3) > 0 {
+					at += random.Float64() * 30
+				}
+			}
+			s.notes[0].onset = testStart
+
+			treble, bass := s.staves()
+			for _, notes := range [][]playedNote{treble, bass} {
+				checkMeasures(t, s.measures(notes, s.measureCount()))
+			}
+		}
+	}
+}
+
+func TestMeasuresTriplets(t *testing.T) {
+	s := session{start: testStart}
+	//a little off, the way they are played
+	for i, n := range []struct{ at, length float64 }{
+		{0, 7.6}, {8.4, 7.6}, {15.8, 7.6},
+		{24, 6}, {36, 6},
+		{48.3, 7.6}, {56, 7.6}, {63.6, 7.6},
+	} {
+		s.notes = append(s.notes, testNote(byte(60+i), n.at, n.at+n.length))
+	}
+
+	measures := s.measures(s.notes, s.measureCount())
+	checkMeasures(t, measures)
+
+	want := []struct {
+		start   int
+		ticks   int
+		triplet bool
+		rest    bool
+	}{
+		{0, 8, true, false},
+		{8, 8, true, false},
+		{16, 8, true, false},
+		{24, 6, false, false},
+		{30, 6, false, true},
+		{36, 6, false, false},
+		{42, 6, false, true},
+		{48, 8, true, false},
+		{56, 8, true, false},
+		{64, 8, true, false},
+		{72, 24, false, true},
+	}
+
+	got := measures[0]
+	if len(got) != len(want) {
+		t.Fatalf("got %d chords, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		c := got[i]
+		if c.start != w.start || c.value.ticks != w.ticks || c.value.triplet != w.triplet || c.isRest() != w.rest {
+			t.Errorf("chord %d: got %+v, want %+v", i, c, w)
+		}
+	}
+}
+
+func TestMeasuresHeldNotes(t *testing.T) {
+	//C held for a half note under E and G played as quarters
+	s := session{start: testStart, notes: []playedNote{
+		testNote(60, 0, 48),
+		testNote(64, 0, 24),
+		testNote(67, 24, 48),
+	}}
+
+	measures := s.measures(s.notes, s.measureCount())
+	checkMeasures(t, measures)
+
+	first, second := measures[0][0], measures[0][1]
+	if len(first.notes) != 2 || first.notes[0].note != 60 || !first.tiedToNext[0] || first.tiedToNext[1] {
+		t.Errorf("first chord: got %+v", first)
+	}
+	if len(second.notes) != 2 || second.notes[0].note != 60 || !second.tiedFromPrevious[0] || second.tiedFromPrevious[1] {
+		t.Errorf("second chord: got %+v", second)
+	}
+}
+
+func TestMeasuresNoteRepeated(t *testing.T) {
+	//the same key played again before it was let go starts over
+	s := session{start: testStart, notes: []playedNote{
+		testNote(60, 0, 48),
+		testNote(60, 24, 72),
+	}}
+
+	measures := s.measures(s.notes, s.measureCount())
+	checkMeasures(t, measures)
+
+	if c := measures[0][1]; c.start != 24 || len(c.notes) != 1 || c.tiedFromPrevious[0] {
+		t.Errorf("second chord: got %+v", c)
+	}
+}
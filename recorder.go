@@ -0,0 +1,120 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+//recorder keeps every channel event that came in, so it can be saved as a
+//midi file (or written out as a score) later
+type recorder struct {
+	outputs []recordingOutput
+
+	mutex  sync.Mutex
+	events []midiEvent
+}
+
+//recordingOutput is a file the recording is saved to, and how
+type recordingOutput struct {
+	path  string
+	write func(w io.Writer, r *recorder) error
+}
+
+//recording is nil unless something should be saved
+var recording *recorder
+
+func newRecorder() *recorder {
+	return &recorder{}
+}
+
+//saveAs adds a file to write the recording to every time it is saved
+func (r *recorder) saveAs(path string, write func(w io.Writer, r *recorder) error) {
+	r.outputs = append(r.outputs, recordingOutput{path, write})
+}
+
+func (r *recorder) add(ev midiEvent) {
+	if ev.status >= SYSTEM {
+		//only channel events make sense in the file
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.events = append(r.events, ev)
+}
+
+//smfEvents converts the timestamps to ticks at the default tempo, counting
+//from the first event so the file doesn't start with a long silence
+func (r *recorder) smfEvents() []smfEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ret := []smfEvent{
+		tempoEvent(0, smfDefaultTempo),
+		timeSignatureEvent(0, 4, 2),
+	}
+	if len(r.events) == 0 {
+		return ret
+	}
+
+	start := r.events[0].time
+
+	for _, ev := range r.events {
+		micros := ev.time.Sub(start).Microseconds()
+		ret = append(ret, smfEvent{
+			ticks: uint32(micros * smfDivision / smfDefaultTempo),
+			data:  ev.bytes(),
+		})
+	}
+
+	return ret
+}
+
+//writeRecordingSMF writes the recording as a midi file
+func writeRecordingSMF(w io.Writer, r *recorder) error {
+	return writeSMF(w, r.smfEvents())
+}
+
+//save writes everything recorded so far to one output. Recording keeps
+//going afterwards, so saving again later gives a longer file.
+func (o recordingOutput) save(r *recorder) error {
+	f, err := os.Create(o.path)
+	if err != nil {
+		return err
+	}
+
+	err = o.write(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+//saveRecording saves the recording to every output if there is one, and
+//tells the user about it. ok is false if any of them couldn't be written.
+func saveRecording() (ok bool) {
+	if recording == nil {
+		return true
+	}
+
+	ok = true
+	for _, output := range recording.outputs {
+		if err := output.save(recording); err != nil {
+			fmt.Println("# Unable to save", output.path+":", err, "#")
+			ok = false
+			continue
+		}
+
+		fmt.Println("# Saved recording to", output.path, "#")
+	}
+
+	return ok
+}
@@ -0,0 +1,53 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+//renderer is what the score is drawn on: the window, or a file for
+//-snapshot. Glyphs are in the music font at fontSize, text is in a plain
+//font, and both are placed by their top left corner like raylib does.
+type renderer interface {
+	line(from, to rl.Vector2, thickness float32, color rl.Color)
+	rectangle(x, y, w, h float32, color rl.Color)
+	circle(x, y, radius float32, color rl.Color)
+	glyph(text string, pos rl.Vector2, color rl.Color)
+	text(text string, x, y, size float32, color rl.Color)
+	//glyphWidth is how wide glyph would draw text
+	glyphWidth(text string) float32
+}
+
+//canvas is where everything is drawn to
+var canvas renderer = raylibRenderer{}
+
+//raylibRenderer draws to the window, between rl.BeginDrawing and
+//rl.EndDrawing
+type raylibRenderer struct{}
+
+func (raylibRenderer) line(from, to rl.Vector2, thickness float32, color rl.Color) {
+	rl.DrawLineEx(from, to, thickness, color)
+}
+
+func (raylibRenderer) rectangle(x, y, w, h float32, color rl.Color) {
+	rl.DrawRectangle(int32(x), int32(y), int32(w), int32(h), color)
+}
+
+func (raylibRenderer) circle(x, y, radius float32, color rl.Color) {
+	rl.DrawCircle(int32(x), int32(y), radius, color)
+}
+
+func (raylibRenderer) glyph(text string, pos rl.Vector2, color rl.Color) {
+	rl.DrawTextEx(musicFont, text, pos, fontSize, 1, color)
+}
+
+func (raylibRenderer) text(text string, x, y, size float32, color rl.Color) {
+	rl.DrawText(text, int32(x), int32(y), int32(size), color)
+}
+
+func (raylibRenderer) glyphWidth(text string) float32 {
+	return rl.MeasureTextEx(musicFont, text, fontSize, 1).X
+}
@@ -0,0 +1,284 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var numerals = []string{"I", "II", "III", "IV", "V", "VI", "VII"}
+
+//augmented sixth chords by their pitch classes above the tonic, with the
+//lowered sixth degree in the bass
+var augmentedSixths = []struct {
+	name         string
+	pitchClasses []int
+}{
+	{"It+6", []int{0, 6, 8}},
+	{"Fr+6", []int{0, 2, 6, 8}},
+	{"Ger+6", []int{0, 3, 6, 8}},
+}
+
+//inversion figures for triads and seventh chords, by inversion
+var (
+	triadFigures   = []string{"", "6", "64"}
+	seventhFigures = []string{"7", "65", "43", "42"}
+)
+
+//romanNumeral analyses some notes in the current key, e.g. "V65/V" or "♭VI".
+//ok is false if they don't make a chord.
+func romanNumeral(notes []byte) (string, bool) {
+	if name, ok := augmentedSixth(notes); ok {
+		return name, true
+	}
+
+	c, ok := recogniseChord(notes)
+	if !ok {
+		return "", false
+	}
+
+	numeral := chordNumeral(c)
+	if c.inversion < 0 {
+		//figures can't say which note is in the bass if it isn't part of the
+		//chord, so it is written as a scale degree instead
+		numeral += "/" + bassDegree(c.bass)
+	}
+
+	return numeral, true
+}
+
+//chordNumeral is the numeral of a recognised chord, without a bass that
+//doesn't belong to it
+func chordNumeral(c chord) string {
+	degree := scaleDegree(c.root)
+
+	if isDiatonic(c) {
+		return numeralFor(c, degree)
+	}
+
+	//a major triad on the lowered second degree
+	tonic := currentKey().tonicPitch()
+	if c.kind.suffix == "" && degree == 2 && c.root.pitchClass() == (tonic+1)%12 {
+		return "N" + figures(c)
+	}
+
+	if secondary, ok := secondaryFunction(c); ok {
+		return secondary
+	}
+
+	//borrowed or otherwise chromatic, e.g. ♭VI in major
+	prefix := ""
+	if alter := c.root.alter - currentKey().degree(degree).alter; alter != 0 {
+		prefix = accidentalString(alter)
+	}
+
+	return prefix + numeralFor(c, degree)
+}
+
+//bassDegree writes a note as a scale degree of the current key, e.g. "2"
+//or "♭7"
+func bassDegree(n spelledNote) string {
+	degree := scaleDegree(n)
+
+	prefix := ""
+	if alter := n.alter - currentKey().degree(degree).alter; alter != 0 {
+		prefix = accidentalString(alter)
+	}
+
+	return prefix + fmt.Sprint(degree)
+}
+
+//augmentedSixth recognises Italian, French and German sixth chords, which
+//are spelled differently from any chord in chordTypes
+func augmentedSixth(notes []byte) (string, bool) {
+	if len(notes) == 0 {
+		return "", false
+	}
+
+	tonic := currentKey().tonicPitch()
+	bass := notes[0]
+	present := map[int]bool{}
+	for _, n := range notes {
+		present[(int(n)-tonic+12)%12] = true
+		if n < bass {
+			bass = n
+		}
+	}
+
+	if (int(bass)-tonic+12)%12 != 8 {
+		return "", false
+	}
+
+	for _, sixth := range augmentedSixths {
+		if len(present) != len(sixth.pitchClasses) {
+			continue
+		}
+
+		matches := true
+		for _, pc := range sixth.pitchClasses {
+			matches = matches && present[pc]
+		}
+		if matches {
+			return sixth.name, true
+		}
+	}
+
+	return "", false
+}
+
+//scaleDegree is which degree of the current key a note is on, from 1 to 7,
+//going by the letter only
+func scaleDegree(n spelledNote) int {
+	return (letterIndex(n.letter)-letterIndex(currentKey().tonic)+7)%7 + 1
+}
+
+//inKey reports whether a note is in the key, or is one of the notes the
+//mode expects outside of it like the leading tone in minor
+func inKey(n spelledNote) bool {
+	if n.alter == keyAlter(n.letter) {
+		return true
+	}
+
+	for _, hint := range currentKey().hints() {
+		if hint.letter == n.letter && hint.alter == n.alter {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isDiatonic(c chord) bool {
+	for _, tone := range c.tones {
+		if !inKey(tone) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//secondaryFunction recognises secondary dominants and leading tone chords,
+//e.g. V7/V or viio7/ii
+func secondaryFunction(c chord) (string, bool) {
+	//the degree the chord has in the key it points to
+	var target spelledNote
+	var function int
+	switch c.kind.suffix {
+	case "", "7", "9", "7♭9":
+		target = c.root.above(perfFourth)
+		function = 5
+	case "dim", "dim7", "m7♭5":
+		target = c.root.above(chordTone{1, 1})
+		function = 7
+	default:
+		return "", false
+	}
+
+	degree := scaleDegree(target)
+	if degree == 1 || target.alter != currentKey().degree(degree).alter {
+		return "", false
+	}
+
+	third, fifth := diatonicTriad(degree)
+	if third == 3 && fifth == 6 {
+		//diminished chords aren't tonicised
+		return "", false
+	}
+
+	targetNumeral := numerals[degree-1]
+	if third == 3 && !(degree == 5 && len(currentKey().hints()) > 0) {
+		targetNumeral = strings.ToLower(targetNumeral)
+	}
+
+	return numeralFor(c, function) + "/" + targetNumeral, true
+}
+
+//diatonicTriad returns the semitones from a scale degree to the third and
+//fifth above it in the current key
+func diatonicTriad(degree int) (third, fifth int) {
+	root := currentKey().degree(degree).pitchClass()
+	third = (currentKey().degree(degree+2).pitchClass() - root + 12) % 12
+	fifth = (currentKey().degree(degree+4).pitchClass() - root + 12) % 12
+
+	return third, fifth
+}
+
+//numeralFor writes a chord as a numeral on a degree: upper case for major
+//chords, lower case for minor ones, then the quality and inversion
+func numeralFor(c chord, degree int) string {
+	numeral := numerals[degree-1]
+
+	minor := false
+	for _, tone := range c.kind.tones {
+		if tone == minThird {
+			minor = true
+		}
+	}
+	if minor {
+		numeral = strings.ToLower(numeral)
+	}
+
+	quality := c.kind.suffix
+	switch quality {
+	case "m":
+		quality = ""
+	case "dim":
+		quality = "o"
+	case "aug":
+		quality = "+"
+	case "m7":
+		quality = "7"
+	case "m(maj7)":
+		quality = "maj7"
+	case "dim7":
+		quality = "o7"
+	case "m7♭5":
+		quality = "ø7"
+	case "6", "m6":
+		//a plain 6 would read as a first inversion
+		quality = "add6"
+	default:
+		if minor {
+			quality = strings.TrimPrefix(quality, "m")
+		}
+	}
+
+	return numeral + withFigures(quality, c)
+}
+
+//figures is the inversion of a triad in figured bass, e.g. "6" for first
+//inversion
+func figures(c chord) string {
+	return withFigures("", c)
+}
+
+//withFigures adds the inversion to the quality of a triad or seventh chord,
+//so a dominant seventh in first inversion becomes "65" instead of "7".
+//Bigger chords and slash chords are left alone.
+func withFigures(quality string, c chord) string {
+	if c.inversion < 0 {
+		return quality
+	}
+
+	switch len(c.kind.tones) {
+	case 3:
+		if c.inversion < len(triadFigures) && !strings.HasPrefix(quality, "sus") {
+			return quality + triadFigures[c.inversion]
+		}
+	case 4:
+		if strings.HasSuffix(quality, "7") && c.inversion < len(seventhFigures) {
+			return strings.TrimSuffix(quality, "7") + seventhFigures[c.inversion]
+		}
+	}
+
+	return quality
+}
+
+//romanNumeral analyses what is sounding right now in the current key
+func (s stateSnapshot) romanNumeral() (string, bool) {
+	return romanNumeral(s.noteNumbers())
+}
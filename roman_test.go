@@ -0,0 +1,63 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRomanNumeral(t *testing.T) {
+	tests := []struct {
+		key   string
+		notes []byte
+		want  string
+	}{
+		{"C", []byte{48, 64, 67}, "I"},
+		{"C", []byte{52, 60, 67}, "I6"},
+		{"C", []byte{55, 60, 64}, "I64"},
+		{"C", []byte{50, 65, 69}, "ii"},
+		{"C", []byte{55, 59, 62, 65}, "V7"},
+		{"C", []byte{59, 62, 65, 67}, "V65"},
+		{"C", []byte{50, 54, 57, 60}, "V7/V"},
+		{"C", []byte{56, 60, 63}, "♭VI"},
+		{"C", []byte{49, 53, 56}, "N"},
+		{"Am", []byte{52, 56, 59}, "V"},
+		{"Am", []byte{57, 60, 64}, "i"},
+
+		//diminished sevenths in every inversion, rooted on the leading tone
+		{"C", []byte{59, 62, 65, 68}, "viio7"},
+		{"C", []byte{50, 53, 56, 59}, "viio65"},
+		{"C", []byte{53, 56, 59, 62}, "viio43"},
+		{"C", []byte{56, 59, 62, 65}, "viio42"},
+		{"Am", []byte{56, 59, 62, 65}, "viio7"},
+		{"Am", []byte{59, 62, 65, 68}, "viio65"},
+		{"Am", []byte{50, 53, 56, 59}, "viio43"},
+		//or on the leading tone of another degree
+		{"C", []byte{54, 57, 60, 63}, "viio7/V"},
+		{"C", []byte{48, 63, 66, 69}, "viio43/V"},
+		{"C", []byte{49, 52, 55, 58}, "viio7/ii"},
+
+		//added sixths aren't inversions
+		{"C", []byte{53, 57, 60, 62}, "IVadd6"},
+		{"Am", []byte{53, 57, 60, 62}, "VIadd6"},
+		{"C", []byte{50, 53, 57, 59}, "iiadd6"},
+		{"C", []byte{50, 53, 57, 60}, "ii7"},
+
+		//augmented sixths
+		{"C", []byte{56, 60, 66}, "It+6"},
+		{"C", []byte{56, 60, 62, 66}, "Fr+6"},
+		{"C", []byte{56, 60, 63, 66}, "Ger+6"},
+	}
+
+	for _, test := range tests {
+		restore := inKeyOf(t, test.key)
+		got, ok := romanNumeral(test.notes)
+		restore()
+
+		if !ok || got != test.want {
+			t.Errorf("%v in %s: got %q, want %q", test.notes, test.key, got, test.want)
+		}
+	}
+}
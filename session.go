@@ -0,0 +1,172 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+//pedalChange is a pedal going down or up
+type pedalChange struct {
+	at         time.Time
+	controller byte
+	down       bool
+}
+
+//session is a recording turned into notes, ready to be written as a score
+type session struct {
+	//sorted by onset
+	notes  []playedNote
+	pedals []pedalChange
+	//the first note, where the first measure starts
+	start time.Time
+}
+
+//session replays everything recorded so far. Keys that are still down are
+//let go at the last event.
+func (r *recorder) session() session {
+	return r.sessionUntil(time.Time{})
+}
+
+//sessionUntil is like session, but lets go of the keys that are still down
+//at end instead
+func (r *recorder) sessionUntil(end time.Time) session {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s := session{}
+	if len(r.events) == 0 {
+		return s
+	}
+
+	type key struct {
+		channel, note byte
+	}
+	down := map[key]playedNote{}
+	if end.IsZero() {
+		end = r.events[len(r.events)-1].time
+	}
+
+	for _, ev := range r.events {
+		switch ev.kind() {
+		case NOTE_ON, NOTE_OFF:
+			k := key{ev.channel(), ev.data[0]}
+			if p, ok := down[k]; ok {
+				p.release = ev.time
+				s.notes = append(s.notes, p)
+				delete(down, k)
+			}
+
+			if ev.kind() == NOTE_ON && ev.data[1] > 0 {
+				down[k] = playedNote{
+					note:     ev.data[0],
+					channel:  ev.channel(),
+					velocity: ev.data[1],
+					onset:    ev.time,
+					source:   ev.source,
+				}
+			}
+
+		case CONTROL:
+			if ev.data[0] != SUSTAIN && ev.data[0] != SOSTENUTO {
+				continue
+			}
+
+			isDown := ev.data[1] >= pedalDownValue
+			last := len(s.pedals) - 1
+			for last >= 0 && s.pedals[last].controller != ev.data[0] {
+				last--
+			}
+			//pedals send lots of values in between, only changes count
+			if (last < 0 && isDown) || (last >= 0 && s.pedals[last].down != isDown) {
+				s.pedals = append(s.pedals, pedalChange{ev.time, ev.data[0], isDown})
+			}
+		}
+	}
+
+	for _, p := range down {
+		p.release = end
+		s.notes = append(s.notes, p)
+	}
+
+	sort.SliceStable(s.notes, func(i, j int) bool {
+		return s.notes[i].onset.Before(s.notes[j].onset)
+	})
+	if len(s.notes) > 0 {
+		s.start = s.notes[0].onset
+	}
+
+	return s
+}
+
+//staves splits the notes between the treble and bass staff the same way
+//the timeline does, at middle C
+func (s session) staves() (treble, bass []playedNote) {
+	for _, p := range s.notes {
+		if p.note >= 60 {
+			treble = append(treble, p)
+		} else {
+			bass = append(bass, p)
+		}
+	}
+
+	return treble, bass
+}
+
+//grid is what the notes of both staves snap to
+func (s session) grid() grid {
+	return newGrid(s.start, s.notes)
+}
+
+//measureCount is how many measures it takes to write down the session
+func (s session) measureCount() int {
+	last := 0
+	for _, c := range notate(s.notes, s.grid()) {
+		if end := c.start + c.value.ticks; end > last {
+			last = end
+		}
+	}
+
+	count := (last + measureTicks() - 1) / measureTicks()
+	if count < 1 {
+		count = 1
+	}
+
+	return count
+}
+
+//measures quantizes a staff and sorts the result into count measures, with
+//rests filling whatever isn't played
+func (s session) measures(notes []playedNote, count int) [][]notatedChord {
+	measure := measureTicks()
+	g := s.grid()
+	ret := make([][]notatedChord, count)
+
+	position := 0
+	add := func(c notatedChord) {
+		if n := c.start / measure; n < len(ret) {
+			ret[n] = append(ret[n], c)
+		}
+		position = c.start + c.value.ticks
+	}
+
+	for _, c := range notate(notes, g) {
+		if c.start > position {
+			for _, rest := range g.notateSpan(position, c.start, nil, nil, nil) {
+				add(rest)
+			}
+		}
+		add(c)
+	}
+
+	if end := len(ret) * measure; position < end {
+		for _, rest := range g.notateSpan(position, end, nil, nil, nil) {
+			add(rest)
+		}
+	}
+
+	return ret
+}
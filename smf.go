@@ -0,0 +1,255 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+//constants taken from the standard midi file spec
+const (
+	META            = 0xFF
+	META_TEMPO      = 0x51
+	META_TIME_SIG   = 0x58
+	META_END_TRACK  = 0x2F
+	smfDivision     = 480    //ticks per quarter note
+	smfDefaultTempo = 500000 //µs per quarter note, i.e. 120 bpm
+)
+
+//smfEvent is an event in a midi file track, at an absolute time in ticks
+type smfEvent struct {
+	ticks uint32
+	//the event as it is written to the file, without the delta time
+	data []byte
+}
+
+func tempoEvent(ticks uint32, microsPerQuarter uint32) smfEvent {
+	return smfEvent{
+		ticks: ticks,
+		data: []byte{
+			META, META_TEMPO, 3,
+			byte(microsPerQuarter >> 16),
+			byte(microsPerQuarter >> 8),
+			byte(microsPerQuarter),
+		},
+	}
+}
+
+//timeSignatureEvent is always in quarter notes with the usual 24 clocks per
+//metronome click and 8 32nds per quarter
+func timeSignatureEvent(ticks uint32, numerator byte, denominatorPower byte) smfEvent {
+	return smfEvent{
+		ticks: ticks,
+		data:  []byte{META, META_TIME_SIG, 4, numerator, denominatorPower, 24, 8},
+	}
+}
+
+//writeSMF writes a type 0 standard midi file with a single track holding
+//the given events, which have to be sorted by time
+func writeSMF(w io.Writer, events []smfEvent) error {
+	track := []byte{}
+
+	last := uint32(0)
+	for _, ev := range events {
+		track = appendVarLen(track, ev.ticks-last)
+		track = append(track, ev.data...)
+		last = ev.ticks
+	}
+	track = append(track, 0, META, META_END_TRACK, 0)
+
+	out := bufio.NewWriter(w)
+
+	out.WriteString("MThd")
+	binary.Write(out, binary.BigEndian, uint32(6))
+	binary.Write(out, binary.BigEndian, uint16(0)) //format
+	binary.Write(out, binary.BigEndian, uint16(1)) //number of tracks
+	binary.Write(out, binary.BigEndian, uint16(smfDivision))
+
+	out.WriteString("MTrk")
+	binary.Write(out, binary.BigEndian, uint32(len(track)))
+	out.Write(track)
+
+	return out.Flush()
+}
+
+//appendVarLen appends a variable length quantity, 7 bits per byte with the
+//high bit set on every byte but the last
+func appendVarLen(b []byte, n uint32) []byte {
+	groups := []byte{byte(n & 0x7F)}
+	for n >>= 7; n > 0; n >>= 7 {
+		groups = append(groups, byte(n&0x7F)|0x80)
+	}
+
+	for i := len(groups) - 1; i >= 0; i-- {
+		b = append(b, groups[i])
+	}
+
+	return b
+}
+
+//smfFile is a parsed standard midi file
+type smfFile struct {
+	format   uint16
+	division uint16
+	tracks   [][]smfEvent
+}
+
+//parseSMF reads a standard midi file of any format. Running status is
+//expanded, so every channel event in the result starts with its status byte.
+func parseSMF(r io.Reader) (smfFile, error) {
+	in := bufio.NewReader(r)
+	f := smfFile{}
+
+	id, header, err := readChunk(in)
+	if err != nil {
+		return f, err
+	}
+	if id != "MThd" || len(header) < 6 {
+		return f, errors.New("not a midi file")
+	}
+
+	f.format = binary.BigEndian.Uint16(header[0:])
+	trackCount := binary.BigEndian.Uint16(header[2:])
+	f.division = binary.BigEndian.Uint16(header[4:])
+
+	if f.division == 0 {
+		return f, errors.New("invalid time division")
+	}
+	if f.division&0x8000 != 0 {
+		//SMPTE timing, which needs a positive frame rate and ticks per frame
+		framesPerSecond := -int8(f.division >> 8)
+		if framesPerSecond <= 0 || f.division&0xFF == 0 {
+			return f, errors.New("invalid SMPTE time division")
+		}
+	}
+
+	for len(f.tracks) < int(trackCount) {
+		id, chunk, err := readChunk(in)
+		if err != nil {
+			return f, err
+		}
+
+		if id != "MTrk" {
+			//unknown chunks are to be skipped
+			continue
+		}
+
+		track, err := parseTrack(chunk)
+		if err != nil {
+			return f, err
+		}
+		f.tracks = append(f.tracks, track)
+	}
+
+	return f, nil
+}
+
+func readChunk(in io.Reader) (string, []byte, error) {
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(in, head); err != nil {
+		return "", nil, err
+	}
+
+	chunk := make([]byte, binary.BigEndian.Uint32(head[4:]))
+	_, err := io.ReadFull(in, chunk)
+
+	return string(head[:4]), chunk, err
+}
+
+func parseTrack(chunk []byte) ([]smfEvent, error) {
+	ret := []smfEvent{}
+	errTruncated := errors.New("truncated track")
+
+	pos := 0
+	ticks := uint32(0)
+	runningStatus := byte(0)
+
+	//readVarLen is bounds checked, n < 0 means the track ended early
+	readVarLen := func() int {
+		n := 0
+		for i := 0; i < 4; i++ {
+			if pos >= len(chunk) {
+				return -1
+			}
+
+			b := chunk[pos]
+			pos++
+			n = n<<7 | int(b&0x7F)
+
+			if b&0x80 == 0 {
+				return n
+			}
+		}
+
+		return -1
+	}
+
+	for pos < len(chunk) {
+		delta := readVarLen()
+		if delta < 0 || pos >= len(chunk) {
+			return ret, errTruncated
+		}
+		ticks += uint32(delta)
+
+		start := pos
+		status := chunk[pos]
+
+		switch {
+		case status == META:
+			pos += 2
+			if pos > len(chunk) {
+				return ret, errTruncated
+			}
+			length := readVarLen()
+			if length < 0 || pos+length > len(chunk) {
+				return ret, errTruncated
+			}
+			pos += length
+
+			ret = append(ret, smfEvent{ticks: ticks, data: chunk[start:pos]})
+
+			if chunk[start+1] == META_END_TRACK {
+				return ret, nil
+			}
+
+		case status == SYSTEM_EXCLUSIVE || status == SYSTEM_END_EXCLUSIVE:
+			pos++
+			length := readVarLen()
+			if length < 0 || pos+length > len(chunk) {
+				return ret, errTruncated
+			}
+			pos += length
+			runningStatus = 0
+
+			ret = append(ret, smfEvent{ticks: ticks, data: chunk[start:pos]})
+
+		default:
+			data := []byte{}
+			if status&0x80 != 0 {
+				runningStatus = status
+				pos++
+			} else if runningStatus == 0 {
+				return ret, errors.New("data byte without status")
+			}
+
+			length := dataLength(runningStatus)
+			if pos+length > len(chunk) {
+				return ret, errTruncated
+			}
+
+			data = append(data, runningStatus)
+			data = append(data, chunk[pos:pos+length]...)
+			pos += length
+
+			ret = append(ret, smfEvent{ticks: ticks, data: data})
+		}
+	}
+
+	//the end of track event is mandatory, but being lenient costs nothing
+	return ret, nil
+}
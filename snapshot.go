@@ -0,0 +1,184 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+//snapshotPath is where -snapshot keeps a picture of what is being played
+var snapshotPath string
+
+//the notes in the last snapshot, to only write it again when they change
+var snapshotNotes []byte
+
+//isPNG tells the two kinds of snapshot apart. PNGs need the GPU to draw,
+//so they can't be made on a server and can't follow what is being played.
+func isPNG(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".png")
+}
+
+//parseNote understands a note as a midi number or a name with an octave,
+//e.g. 60, C4, F#3 or Bb-1
+func parseNote(s string) (byte, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 || n > 127 {
+			return 0, errors.New("midi notes go from 0 to 127")
+		}
+		return byte(n), nil
+	}
+
+	if s == "" {
+		return 0, errors.New("empty note")
+	}
+
+	letter := strings.ToUpper(s[:1])[0]
+	if letterIndex(letter) < 0 {
+		return 0, errors.New("unknown note " + s[:1])
+	}
+
+	alter := 0
+	rest := s[1:]
+	for {
+		switch {
+		case strings.HasPrefix(rest, "#"):
+			alter++
+			rest = rest[1:]
+			continue
+		case strings.HasPrefix(rest, "♯"):
+			alter++
+			rest = rest[len("♯"):]
+			continue
+		case strings.HasPrefix(rest, "♭"):
+			alter--
+			rest = rest[len("♭"):]
+			continue
+		case strings.HasPrefix(rest, "b"):
+			alter--
+			rest = rest[1:]
+			continue
+		}
+		break
+	}
+
+	octave, err := strconv.Atoi(rest)
+	if err != nil {
+		return 0, errors.New("missing octave in " + s)
+	}
+
+	n := spelledNote{letter, alter, octave}.midi()
+	if n < 0 || n > 127 {
+		return 0, errors.New(s + " is out of range")
+	}
+
+	return byte(n), nil
+}
+
+//pressNotes holds the notes down as if they were played, for a snapshot of
+//notes given on the command line
+func pressNotes(notes []byte) {
+	for _, n := range notes {
+		state.noteOn(midiEvent{
+			status: NOTE_ON,
+			data:   []byte{n, 64},
+			time:   time.Now(),
+		})
+	}
+}
+
+//writeSnapshot draws the score with what is being played right now to
+//path, as an SVG or PNG image
+func writeSnapshot(path string) error {
+	frame = state.snapshot()
+
+	if isPNG(path) {
+		return writePNGSnapshot(path)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	svg := newSVGRenderer(file)
+	drawOn(svg)
+	if err := svg.finish(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+//drawOn draws the score onto r instead of the window
+func drawOn(r renderer) {
+	previous, previousWidth := canvas, noteWidth
+	defer func() {
+		canvas, noteWidth = previous, previousWidth
+	}()
+
+	canvas, noteWidth = r, r.glyphWidth("")
+	drawScore()
+}
+
+//writePNGSnapshot draws into a texture the size of the window, which needs
+//a window (that is closed again right away) to exist
+func writePNGSnapshot(path string) error {
+	rl.SetTraceLog(rl.LogNone)
+	rl.InitWindow(width, height, "Live Score")
+	defer rl.CloseWindow()
+	loadMusicFont()
+
+	target := rl.LoadRenderTexture(width, height)
+	defer rl.UnloadRenderTexture(target)
+
+	rl.BeginTextureMode(target)
+	rl.ClearBackground(BGCOL)
+	drawScore()
+	rl.EndTextureMode()
+
+	image := rl.GetTextureData(target.Texture)
+	//textures are stored upside down
+	rl.ImageFlipVertical(image)
+
+	//raylib doesn't say whether it could write the file, so any old one has
+	//to go first for that to show
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	rl.ExportImage(*image, path)
+	if _, err := os.Stat(path); err != nil {
+		return errors.New("the image couldn't be written")
+	}
+
+	return nil
+}
+
+//updateSnapshot writes the snapshot again if different notes are held.
+//It draws with the same globals as the window, so it has to be called from
+//wherever the window is drawn, or instead of it without the GUI.
+func updateSnapshot() {
+	if snapshotPath == "" {
+		return
+	}
+
+	notes := state.snapshot().noteNumbers()
+	if snapshotNotes != nil && string(notes) == string(snapshotNotes) {
+		return
+	}
+	snapshotNotes = notes
+
+	if err := writeSnapshot(snapshotPath); err != nil {
+		fmt.Println("# Unable to save", snapshotPath+":", err, "#")
+	}
+}
@@ -0,0 +1,141 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const letters = "CDEFGAB"
+
+//pitch class of every natural, in the same order as letters
+var letterPitches = [7]int{0, 2, 4, 5, 7, 9, 11}
+
+//spelledNote is a pitch written the way it goes on the staff: a letter, its
+//accidental and the octave
+type spelledNote struct {
+	letter byte
+	//-2 is a double flat, -1 a flat, 0 natural, 1 sharp, 2 double sharp
+	alter int
+	//scientific pitch notation, so middle C is C4. B♯3 sounds the same as
+	//C4 but is written in octave 3.
+	octave int
+}
+
+func letterIndex(letter byte) int {
+	return strings.IndexByte(letters, letter)
+}
+
+//midi returns the midi note number the spelling stands for
+func (n spelledNote) midi() int {
+	return (n.octave+1)*12 + letterPitches[letterIndex(n.letter)] + n.alter
+}
+
+//step counts diatonic steps (staff positions) from middle C, negative below
+func (n spelledNote) step() int {
+	return (n.octave-4)*7 + letterIndex(n.letter)
+}
+
+func accidentalString(alter int) string {
+	return map[int]string{
+		-2: "𝄫",
+		-1: "♭",
+		0:  "♮",
+		1:  "♯",
+		2:  "𝄪",
+	}[alter]
+}
+
+//accidentalGlyph is the SMuFL code point for an accidental
+func accidentalGlyph(alter int) string {
+	return map[int]string{
+		-2: "",
+		-1: "",
+		0:  "",
+		1:  "",
+		2:  "",
+	}[alter]
+}
+
+//String gives e.g. "C♯-4", always with an accidental
+func (n spelledNote) String() string {
+	return fmt.Sprintf("%c%s-%d", n.letter, accidentalString(n.alter), n.octave)
+}
+
+//keyAlter returns how the current key signature changes a letter
+func keyAlter(letter byte) int {
+	return currentKey().alterOf(letter)
+}
+
+//spell picks how to write a midi note in the current key. Notes that are in
+//the key use its accidentals (so B♯ in C♯ major, C♭ in G♭ major), notes
+//the mode expects outside the key are spelled as such (G♯ in A minor) and
+//other notes take whichever spelling is the smallest change from the key, leaning
+//towards sharps or flats like the key signature does.
+func spell(midiValue byte) spelledNote {
+	key := currentKeySettings()
+
+	for _, hint := range key.key.hints() {
+		pitch := letterPitches[letterIndex(hint.letter)] + hint.alter
+		if (int(midiValue)-pitch)%12 == 0 {
+			hint.octave = (int(midiValue)-pitch)/12 - 1
+			return hint
+		}
+	}
+
+	best := spelledNote{}
+	bestScore := -1
+
+	for i := range letters {
+		letter := letters[i]
+
+		for alter := -2; alter <= 2; alter++ {
+			pitch := letterPitches[i] + alter
+			if (int(midiValue)-pitch)%12 != 0 {
+				continue
+			}
+
+			fromKey := alter - key.key.alterOf(letter)
+
+			//lower is better: distance from the key first, then
+			//plain accidentals over double ones, then the direction
+			score := 100*abs(fromKey) + 10*abs(alter)
+			if (key.useFlats && fromKey > 0) || (!key.useFlats && fromKey < 0) {
+				score++
+			}
+
+			if bestScore < 0 || score < bestScore {
+				bestScore = score
+				best = spelledNote{
+					letter: letter,
+					alter:  alter,
+					octave: (int(midiValue)-pitch)/12 - 1,
+				}
+			}
+		}
+	}
+
+	return best
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+func noteName(midiValue byte) string {
+	return spell(midiValue).String()
+}
+
+//isOnLine reports whether a note head sits on a staff (or ledger) line
+//rather than in a space. Middle C has a ledger line, so everything an even
+//number of steps away from it is on a line as well.
+func isOnLine(note byte) bool {
+	return spell(note).step()%2 == 0
+}
@@ -0,0 +1,337 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+//pedals count as pressed from this value on, as per the midi spec
+const pedalDownValue = 64
+
+//heldNote is a note that is currently sounding
+type heldNote struct {
+	note     byte
+	channel  byte
+	velocity byte
+	onset    time.Time
+	source   *midiSource
+
+	//the key was let go, but a pedal keeps the note ringing
+	released bool
+	//caught by the sostenuto pedal, which keeps it ringing until the pedal
+	//goes up
+	captured bool
+}
+
+//playedNote is a note whose key has been let go, kept around for the
+//timeline
+type playedNote struct {
+	note     byte
+	channel  byte
+	velocity byte
+	onset    time.Time
+	release  time.Time
+	source   *midiSource
+}
+
+//historyLength is how long played notes are kept after their release.
+//Nothing is kept if it is zero.
+var historyLength time.Duration
+
+//pedal is the last value a pedal controller was set to
+type pedal struct {
+	value  byte
+	source *midiSource
+}
+
+func (p pedal) isDown() bool {
+	return p.value >= pedalDownValue
+}
+
+//percent is how far the pedal is pressed, from 0 to 1
+func (p pedal) percent() float32 {
+	return float32(p.value) / 127
+}
+
+type channelState struct {
+	held      map[byte]heldNote
+	sustain   pedal
+	sostenuto pedal
+	soft      pedal
+}
+
+//noteState is everything we know about what is being played. It is written
+//to by the midi goroutine and read by the renderer, so every access goes
+//through the mutex.
+type noteState struct {
+	mutex        sync.Mutex
+	channels     [16]channelState
+	lastVelocity byte
+
+	history []playedNote
+	//when the very first note was played, which is where the first
+	//measure starts
+	firstOnset time.Time
+}
+
+//stateSnapshot is a copy of the state that can be used without locking,
+//e.g. for drawing a single frame
+type stateSnapshot struct {
+	//sorted from highest to lowest, each pitch only once
+	notes []heldNote
+
+	//pedals of whichever channel has them pressed furthest
+	sustain   pedal
+	sostenuto pedal
+	soft      pedal
+
+	lastVelocity byte
+
+	//notes that are no longer held, in the order they were let go
+	history    []playedNote
+	firstOnset time.Time
+}
+
+var state = newNoteState()
+
+func newNoteState() *noteState {
+	s := &noteState{}
+	for i := range s.channels {
+		s.channels[i].held = map[byte]heldNote{}
+	}
+
+	return s
+}
+
+func (s *noteState) noteOn(ev midiEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	onset := eventTime(ev)
+	channel := &s.channels[ev.channel()]
+
+	channel.held[ev.data[0]] = heldNote{
+		note:     ev.data[0],
+		channel:  ev.channel(),
+		velocity: ev.data[1],
+		onset:    onset,
+		source:   ev.source,
+		//striking a note again doesn't bring its damper back down
+		captured: channel.held[ev.data[0]].captured,
+	}
+	s.lastVelocity = ev.data[1]
+
+	if s.firstOnset.IsZero() {
+		s.firstOnset = onset
+	}
+}
+
+func (s *noteState) noteOff(ev midiEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	channel := &s.channels[ev.channel()]
+
+	held, ok := channel.held[ev.data[0]]
+	if !ok {
+		return
+	}
+
+	//the pedals may keep it ringing, but as far as the timeline is
+	//concerned the note ends here
+	if !held.released {
+		s.played(held, eventTime(ev))
+	}
+
+	if channel.sustain.isDown() || held.captured {
+		held.released = true
+		channel.held[ev.data[0]] = held
+		return
+	}
+
+	delete(channel.held, ev.data[0])
+}
+
+//control updates the pedals, other controllers are ignored
+func (s *noteState) control(ev midiEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	channel := &s.channels[ev.channel()]
+	p := pedal{value: ev.data[1], source: ev.source}
+
+	switch ev.data[0] {
+	case SUSTAIN:
+		channel.sustain = p
+		if !p.isDown() {
+			channel.releaseSustained()
+		}
+	case SOSTENUTO:
+		wasDown := channel.sostenuto.isDown()
+		channel.sostenuto = p
+
+		if p.isDown() && !wasDown {
+			channel.capture()
+		} else if !p.isDown() && wasDown {
+			channel.releaseCaptured()
+		}
+	case SOFT_PEDAL:
+		channel.soft = p
+	}
+}
+
+//releaseSustained stops every note that was only held by the sustain pedal
+func (c *channelState) releaseSustained() {
+	for note, held := range c.held {
+		if held.released && !held.captured {
+			delete(c.held, note)
+		}
+	}
+}
+
+//capture makes the sostenuto pedal hold every note whose key is down
+func (c *channelState) capture() {
+	for note, held := range c.held {
+		if !held.released {
+			held.captured = true
+			c.held[note] = held
+		}
+	}
+}
+
+//releaseCaptured lets go of the notes caught by the sostenuto pedal, unless
+//the sustain pedal still holds them
+func (c *channelState) releaseCaptured() {
+	for note, held := range c.held {
+		if !held.captured {
+			continue
+		}
+
+		held.captured = false
+		if held.released && !c.sustain.isDown() {
+			delete(c.held, note)
+		} else {
+			c.held[note] = held
+		}
+	}
+}
+
+//releaseSource forgets every note and pedal that came from a device, e.g.
+//because it was unplugged
+func (s *noteState) releaseSource(source *midiSource) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.channels {
+		channel := &s.channels[i]
+
+		for note, held := range channel.held {
+			if held.source == source {
+				if !held.released {
+					s.played(held, time.Now())
+				}
+				delete(channel.held, note)
+			}
+		}
+
+		for _, p := range []*pedal{&channel.sustain, &channel.sostenuto, &channel.soft} {
+			if p.source == source {
+				*p = pedal{}
+			}
+		}
+	}
+}
+
+//played adds a note to the history and forgets those that are too old.
+//Must be called with the mutex held.
+func (s *noteState) played(held heldNote, release time.Time) {
+	if historyLength == 0 {
+		return
+	}
+
+	kept := s.history[:0]
+	for _, p := range s.history {
+		if release.Sub(p.release) <= historyLength {
+			kept = append(kept, p)
+		}
+	}
+
+	s.history = append(kept, playedNote{
+		note:     held.note,
+		channel:  held.channel,
+		velocity: held.velocity,
+		onset:    held.onset,
+		release:  release,
+		source:   held.source,
+	})
+}
+
+func (s *noteState) snapshot() stateSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ret := stateSnapshot{
+		lastVelocity: s.lastVelocity,
+		history:      append([]playedNote{}, s.history...),
+		firstOnset:   s.firstOnset,
+	}
+	all := []heldNote{}
+
+	for _, channel := range s.channels {
+		for _, held := range channel.held {
+			all = append(all, held)
+		}
+
+		if channel.sustain.value > ret.sustain.value {
+			ret.sustain = channel.sustain
+		}
+		if channel.sostenuto.value > ret.sostenuto.value {
+			ret.sostenuto = channel.sostenuto
+		}
+		if channel.soft.value > ret.soft.value {
+			ret.soft = channel.soft
+		}
+	}
+
+	//a pitch held on several channels is shown as the lowest channel has it
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].note != all[j].note {
+			return all[i].note > all[j].note
+		}
+		return all[i].channel < all[j].channel
+	})
+	for i, held := range all {
+		if i == 0 || held.note != all[i-1].note {
+			ret.notes = append(ret.notes, held)
+		}
+	}
+
+	return ret
+}
+
+//pressed returns only the notes whose keys are actually held down
+func (s stateSnapshot) pressed() []heldNote {
+	ret := []heldNote{}
+	for _, held := range s.notes {
+		if !held.released {
+			ret = append(ret, held)
+		}
+	}
+
+	return ret
+}
+
+//noteNumbers returns just the midi note numbers of everything sounding
+func (s stateSnapshot) noteNumbers() []byte {
+	ret := []byte{}
+	for _, held := range s.notes {
+		ret = append(ret, held.note)
+	}
+
+	return ret
+}
@@ -0,0 +1,78 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func noteEvent(status, channel, note, velocity byte) midiEvent {
+	return midiEvent{
+		status: status | channel,
+		data:   []byte{note, velocity},
+		time:   time.Now(),
+	}
+}
+
+//run with -race: the midi goroutines write while the GUI reads
+func TestStateConcurrentAccess(t *testing.T) {
+	s := newNoteState()
+
+	var wg sync.WaitGroup
+	for channel := byte(0); channel < 4; channel++ {
+		wg.Add(1)
+		go func(channel byte) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				note := byte(40 + i%40)
+				s.noteOn(noteEvent(NOTE_ON, channel, note, 100))
+				s.control(noteEvent(CONTROL, channel, SUSTAIN, byte(i%128)))
+				s.control(noteEvent(CONTROL, channel, SOSTENUTO, byte((i*7)%128)))
+				s.noteOff(noteEvent(NOTE_OFF, channel, note, 0))
+			}
+		}(channel)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for {
+		snap := s.snapshot()
+		for i := 1; i < len(snap.notes); i++ {
+			if snap.notes[i].note >= snap.notes[i-1].note {
+				t.Fatalf("snapshot not sorted from highest to lowest without repeats: %v", snap.noteNumbers())
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+func TestSnapshotPrefersLowestChannel(t *testing.T) {
+	for _, channels := range [][]byte{{0, 5, 9}, {9, 5, 0}, {5, 0, 9}} {
+		s := newNoteState()
+		for _, channel := range channels {
+			s.noteOn(noteEvent(NOTE_ON, channel, 60, 10+channel))
+		}
+
+		snap := s.snapshot()
+		if len(snap.notes) != 1 {
+			t.Fatalf("played on channels %v: got %d notes, want 1", channels, len(snap.notes))
+		}
+		if got := snap.notes[0]; got.channel != 0 || got.velocity != 10 {
+			t.Errorf("played on channels %v: got channel %d velocity %d, want channel 0 velocity 10",
+				channels, got.channel, got.velocity)
+		}
+	}
+}
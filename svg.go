@@ -0,0 +1,117 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+//advance widths of the glyphs that get measured, in ems, as Bravura has
+//them. Anything else is assumed to be as wide as a note head.
+var glyphAdvances = map[rune]float32{
+	0xE0A4: 0.236,
+	0xE260: 0.181,
+	0xE262: 0.199,
+	0xE650: 1.325,
+}
+
+//raylib puts the baseline this far (the font's ascent) below the top of
+//the text, in ems
+const musicFontAscent = 0.8
+
+//svgRenderer writes everything drawn onto it as an SVG image. Glyphs are
+//written as text in the music font, which is looked for as Bravura or as
+//musicFont.otf next to the image.
+type svgRenderer struct {
+	out *bufio.Writer
+}
+
+//newSVGRenderer starts an image the size of the window, with the
+//background already filled in
+func newSVGRenderer(w io.Writer) *svgRenderer {
+	r := &svgRenderer{bufio.NewWriter(w)}
+
+	fmt.Fprintf(r.out,
+		"<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		width, height, width, height,
+	)
+	fmt.Fprintln(r.out, "<style>")
+	fmt.Fprintln(r.out, `@font-face { font-family: "live-score music"; src: local("Bravura"), url("musicFont.otf"); }`)
+	fmt.Fprintf(r.out, ".music { font-family: \"live-score music\"; font-size: %gpx; }\n", fontSize)
+	fmt.Fprintln(r.out, ".text { font-family: sans-serif; }")
+	fmt.Fprintln(r.out, "</style>")
+	r.rectangle(0, 0, width, height, BGCOL)
+
+	return r
+}
+
+//finish ends the image
+func (r *svgRenderer) finish() error {
+	fmt.Fprintln(r.out, "</svg>")
+	return r.out.Flush()
+}
+
+//svgColor is a color as fill or stroke (paint) attributes
+func svgColor(paint string, c rl.Color) string {
+	ret := fmt.Sprintf(`%s="#%02x%02x%02x"`, paint, c.R, c.G, c.B)
+	if c.A != 0xFF {
+		ret += fmt.Sprintf(` %s-opacity="%.2f"`, paint, float32(c.A)/0xFF)
+	}
+
+	return ret
+}
+
+func (r *svgRenderer) line(from, to rl.Vector2, thickness float32, color rl.Color) {
+	fmt.Fprintf(r.out, "<line x1=\"%g\" y1=\"%g\" x2=\"%g\" y2=\"%g\" stroke-width=\"%g\" %s/>\n",
+		from.X, from.Y, to.X, to.Y, thickness, svgColor("stroke", color),
+	)
+}
+
+func (r *svgRenderer) rectangle(x, y, w, h float32, color rl.Color) {
+	fmt.Fprintf(r.out, "<rect x=\"%g\" y=\"%g\" width=\"%g\" height=\"%g\" %s/>\n",
+		x, y, w, h, svgColor("fill", color),
+	)
+}
+
+func (r *svgRenderer) circle(x, y, radius float32, color rl.Color) {
+	fmt.Fprintf(r.out, "<circle cx=\"%g\" cy=\"%g\" r=\"%g\" %s/>\n",
+		x, y, radius, svgColor("fill", color),
+	)
+}
+
+func (r *svgRenderer) glyph(text string, pos rl.Vector2, color rl.Color) {
+	fmt.Fprintf(r.out, "<text class=\"music\" x=\"%g\" y=\"%g\" %s>%s</text>\n",
+		pos.X, pos.Y+musicFontAscent*fontSize, svgColor("fill", color), html.EscapeString(text),
+	)
+}
+
+func (r *svgRenderer) text(text string, x, y, size float32, color rl.Color) {
+	//raylib's font has no descent to speak of
+	fmt.Fprintf(r.out, "<text class=\"text\" x=\"%g\" y=\"%g\" font-size=\"%g\" %s>%s</text>\n",
+		x, y+size, size, svgColor("fill", color), html.EscapeString(text),
+	)
+}
+
+func (r *svgRenderer) glyphWidth(text string) float32 {
+	total := float32(0)
+	for i, c := range text {
+		advance, ok := glyphAdvances[c]
+		if !ok {
+			advance = glyphAdvances[0xE0A4]
+		}
+		//raylib adds the spacing between glyphs
+		if i > 0 {
+			total++
+		}
+		total += advance * fontSize
+	}
+
+	return total
+}
@@ -0,0 +1,90 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//timeSignature as in 3/4: beats per measure, and which note gets a beat
+type timeSignature struct {
+	beats int
+	unit  int
+}
+
+var (
+	//--- flags ---
+	showTimeline bool
+	//in quarter notes per minute, whatever the time signature
+	beatsPerMinute   = 100.0
+	timeSig          = timeSignature{4, 4}
+	timelineMeasures = 4
+)
+
+//parseTimeSignature understands e.g. "3/4" or "6/8"
+func parseTimeSignature(s string) (timeSignature, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) != 2 {
+		return timeSignature{}, errors.New("expected something like 3/4")
+	}
+
+	beats, err := strconv.Atoi(parts[0])
+	if err != nil || beats < 1 {
+		return timeSignature{}, errors.New("invalid number of beats " + parts[0])
+	}
+
+	//32nds are the shortest notes that are written, so anything shorter
+	//would make measures that can't be filled
+	unit, err := strconv.Atoi(parts[1])
+	if err != nil || unit < 1 || unit > 32 || unit&(unit-1) != 0 {
+		return timeSignature{}, errors.New("invalid beat unit " + parts[1])
+	}
+
+	return timeSignature{beats, unit}, nil
+}
+
+func (t timeSignature) String() string {
+	return fmt.Sprintf("%d/%d", t.beats, t.unit)
+}
+
+func quarterLength() time.Duration {
+	return time.Duration(float64(time.Minute) / beatsPerMinute)
+}
+
+func measureLength() time.Duration {
+	return quarterLength() * time.Duration(4*timeSig.beats) / time.Duration(timeSig.unit)
+}
+
+//timelineWindow is how far back the timeline goes
+func timelineWindow() time.Duration {
+	return measureLength() * time.Duration(timelineMeasures)
+}
+
+//barlines returns when every measure starting between from and to starts,
+//counting from the first note that was played
+func barlines(firstOnset, from, to time.Time) []time.Time {
+	ret := []time.Time{}
+	if firstOnset.IsZero() {
+		return ret
+	}
+
+	measure := measureLength()
+
+	n := from.Sub(firstOnset) / measure
+	if n < 0 {
+		n = 0
+	}
+	for at := firstOnset.Add(n * measure); !at.After(to); at = at.Add(measure) {
+		if !at.Before(from) {
+			ret = append(ret, at)
+		}
+	}
+
+	return ret
+}
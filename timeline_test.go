@@ -0,0 +1,57 @@
+//This Source Code Form is subject to the terms of the Mozilla Public
+//License, v. 2.0. If a copy of the MPL was not distributed with this
+//file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseTimeSignature(t *testing.T) {
+	tests := []struct {
+		s    string
+		want timeSignature
+		ok   bool
+	}{
+		{"4/4", timeSignature{4, 4}, true},
+		{" 6/8 ", timeSignature{6, 8}, true},
+		{"1/32", timeSignature{1, 32}, true},
+		{"7/32", timeSignature{7, 32}, true},
+		{"5/2", timeSignature{5, 2}, true},
+
+		//measures shorter than a 32nd can't be written
+		{"1/64", timeSignature{}, false},
+		{"7/64", timeSignature{}, false},
+		{"3/128", timeSignature{}, false},
+
+		{"3/6", timeSignature{}, false},
+		{"0/4", timeSignature{}, false},
+		{"4", timeSignature{}, false},
+		{"x/4", timeSignature{}, false},
+	}
+
+	for _, test := range tests {
+		got, err := parseTimeSignature(test.s)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("%q: got %v, %v, want %v", test.s, got, err, test.want)
+		}
+	}
+}
+
+//every time signature that can be given has measures that note values add
+//up to
+func TestTimeSignatureMeasureTicks(t *testing.T) {
+	defer func(previous timeSignature) {
+		timeSig = previous
+	}(timeSig)
+
+	for unit := 1; unit <= 32; unit *= 2 {
+		for beats := 1; beats <= 16; beats++ {
+			timeSig = timeSignature{beats, unit}
+			if ticks := measureTicks(); ticks%3 != 0 || ticks*timeSig.unit != 4*ticksPerQuarter*beats {
+				t.Errorf("%v: %d ticks per measure", timeSig, ticks)
+			}
+		}
+	}
+}